@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sticky is the -sticky flag: the cookie or header carrying the identifier
+// of each virtual user.
+type sticky struct {
+	cookie bool
+	name   string
+}
+
+func (s *sticky) String() string {
+	if s.name == "" {
+		return ""
+	}
+	if s.cookie {
+		return "cookie:" + s.name
+	}
+	return "header:" + s.name
+}
+
+func (s *sticky) Set(value string) error {
+	kind, name, ok := strings.Cut(value, ":")
+	if !ok || name == "" || (kind != "cookie" && kind != "header") {
+		return errorString("Sticky identifier format must be `cookie:NAME' or `header:NAME'")
+	}
+	s.cookie, s.name = kind == "cookie", name
+	return nil
+}
+
+// set adds the identifier of virtual user id to req.
+func (s *sticky) set(req *http.Request, id int) {
+	value := fmt.Sprintf("user-%d", id)
+	if s.cookie {
+		req.AddCookie(&http.Cookie{Name: s.name, Value: value})
+	} else {
+		req.Header.Set(s.name, value)
+	}
+}
+
+// user_affinity holds the backends seen by one virtual user.
+type user_affinity struct {
+	last     string
+	switches int64
+	backends map[string]bool
+}
+
+// affinity_tracker records, per virtual user, the backend named by a
+// response header. Each worker only updates its own user.
+type affinity_tracker struct {
+	header string
+	users  []user_affinity
+}
+
+func new_affinity_tracker(header string, users int) *affinity_tracker {
+	return &affinity_tracker{header: header, users: make([]user_affinity, users)}
+}
+
+// record notes the backend of a response to user id, if named.
+func (a *affinity_tracker) record(id int, resp *http.Response) {
+	backend := resp.Header.Get(a.header)
+	if backend == "" {
+		return
+	}
+	u := &a.users[id]
+	if u.backends == nil {
+		u.backends = make(map[string]bool)
+	}
+	if u.last != "" && u.last != backend {
+		u.switches++
+	}
+	u.last = backend
+	u.backends[backend] = true
+}
+
+// affinity_report describes how consistently users were sent to the same
+// backend.
+type affinity_report struct {
+	Header     string `json:"header"`
+	Users      int    `json:"users"`      // users with a named backend
+	Consistent int    `json:"consistent"` // users served by a single backend
+	Switches   int64  `json:"switches"`
+	Backends   int    `json:"backends"`
+}
+
+func (a *affinity_tracker) report() *affinity_report {
+	r := &affinity_report{Header: a.header}
+	all := make(map[string]bool)
+	for _, u := range a.users {
+		if len(u.backends) == 0 {
+			continue
+		}
+		r.Users++
+		if len(u.backends) == 1 {
+			r.Consistent++
+		}
+		r.Switches += u.switches
+		for b := range u.backends {
+			all[b] = true
+		}
+	}
+	r.Backends = len(all)
+	return r
+}
+
+// print_affinity writes the session affinity block of the summary.
+func print_affinity(w io.Writer, r *affinity_report) {
+	if r == nil {
+		return
+	}
+	if r.Users == 0 {
+		fmt.Fprintf(w, "Affinity: no %s header in the responses\n", r.Header)
+		return
+	}
+	fmt.Fprintf(w, "Affinity: %d of %d users (%.2f%%) stayed on one backend, %d backend switches, %d backends\n",
+		r.Consistent, r.Users, 100*float64(r.Consistent)/float64(r.Users), r.Switches, r.Backends)
+}
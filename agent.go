@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// run_spec is a run requested by a coordinator to an agent: the command
+// line options of the run and its synchronized start time.
+type run_spec struct {
+	Args  []string  `json:"args"`
+	Start time.Time `json:"start"`
+}
+
+// agent_interval holds the statistics of one sampling interval of a run.
+type agent_interval struct {
+	Elapsed    float64 `json:"elapsed_seconds"`
+	Requests   int64   `json:"requests"`
+	Errors     int64   `json:"errors"`
+	Throughput float64 `json:"throughput_tps"`
+	P99        float64 `json:"p99_ms"`
+}
+
+// agent_message is one line of the response to a run request, streamed as
+// newline delimited JSON: interval statistics during the run, then the
+// results or an error.
+type agent_message struct {
+	Interval *agent_interval `json:"interval,omitempty"`
+	Result   *report         `json:"result,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// agent runs the load requested by a coordinator, one run at a time, in a
+// child hammer process.
+type agent struct {
+	mu    sync.Mutex
+	token string    // shared with the coordinators
+	cmd   *exec.Cmd // run in progress, nil if none
+	args  []string
+	since time.Time
+}
+
+// handler returns the control API of the agent, whose requests must carry
+// the token in an Authorization: Bearer header:
+//
+//	POST /run     run the posted run_spec, streaming agent_message lines
+//	POST /stop    stop the run in progress, which returns partial results
+//	GET  /status  tell whether a run is in progress
+func (a *agent) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", authorized(a.token, only("POST", a.run)))
+	mux.HandleFunc("/stop", authorized(a.token, only("POST", a.stop)))
+	mux.HandleFunc("/status", authorized(a.token, only("GET", a.status)))
+	return mux
+}
+
+// only restricts a handler to one method. Method patterns of ServeMux are
+// not used, as GOPATH builds (go 1.21 semantics) take them as paths.
+func only(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// authorized rejects the requests without the bearer token of the agent,
+// or of the control endpoint.
+func authorized(token string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			log.Printf("Unauthorized request from %s", r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// Options that agents refuse, as they run commands, or read or write local
+// files or sockets
+var agent_denied = map[string]bool{
+	"sign-cmd": true, "from-curl": true, "o": true, "timeseries": true, "hgrm": true, "junit": true,
+	"save-failures": true, "failures-file": true, "tls-keylog": true, "cpu-prof": true, "mem-prof": true,
+	"summary-dir": true, "body-file": true, "url-file": true, "data": true, "scenario": true, "flow": true,
+	"har": true, "postman": true, "postman-env": true, "openapi": true, "replay": true, "plan": true,
+	"graphql": true, "variables": true, "proto-set": true, "payload": true, "cacert": true, "cert": true,
+	"key": true, "bearer-file": true, "jwt-key": true, "unix-socket": true,
+}
+
+// check_agent_args returns an error if the options of a run requested to an
+// agent include denied ones, bodies or form fields read from files, or a
+// unix socket target (the URL sources other than -url being denied).
+func check_agent_args(args []string) error {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("Agent runs take options only, not %q", args[0])
+	}
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, value, has_value := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if agent_denied[name] {
+			return fmt.Errorf("Option -%s is not allowed in agent runs", name)
+		}
+		if !has_value && i+1 < len(args) {
+			value = args[i+1]
+		}
+		if (name == "body" && (strings.HasPrefix(value, "@") || value == "-")) || (name == "form" && strings.Contains(value, "=@")) {
+			return fmt.Errorf("Option -%s reading a file is not allowed in agent runs", name)
+		}
+		if name == "url" && strings.HasPrefix(value, "unix://") {
+			return fmt.Errorf("Unix socket targets are not allowed in agent runs")
+		}
+	}
+	return nil
+}
+
+func (a *agent) run(w http.ResponseWriter, r *http.Request) {
+	var spec run_spec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := check_agent_args(spec.Args); err != nil {
+		log.Printf("Run from %s refused: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Interval statistics are read from a pipe on the child's descriptor 3
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer pr.Close()
+	args := append(spec.Args, "-output", "json", "-o", "", "-histograms", "-timeseries", "/dev/fd/3",
+		"-start-at", spec.Start.Format(time.RFC3339Nano))
+	cmd := exec.CommandContext(r.Context(), exe, args...)
+	cmd.ExtraFiles = []*os.File{pw}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	a.mu.Lock()
+	if a.cmd != nil {
+		a.mu.Unlock()
+		pw.Close()
+		http.Error(w, "A run is already in progress", http.StatusConflict)
+		return
+	}
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		a.mu.Unlock()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.cmd, a.args, a.since = cmd, spec.Args, time.Now()
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.cmd = nil
+		a.mu.Unlock()
+	}()
+	log.Printf("Run from %s: %q", r.RemoteAddr, spec.Args)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(m agent_message) {
+		enc.Encode(m)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	read_intervals(pr, func(iv *agent_interval) { send(agent_message{Interval: iv}) })
+
+	err = cmd.Wait()
+	// Exit status 2 means failed thresholds, with results nonetheless
+	if ee, ok := err.(*exec.ExitError); err != nil && !(ok && ee.ExitCode() == 2) {
+		send(agent_message{Error: fmt.Sprintf("%v: %s", err, stderr.String())})
+		return
+	}
+	res := &report{}
+	if err := json.Unmarshal(stdout.Bytes(), res); err != nil {
+		send(agent_message{Error: err.Error()})
+		return
+	}
+	send(agent_message{Result: res})
+	log.Printf("Run from %s done: %d requests", r.RemoteAddr, res.Requests)
+}
+
+// read_intervals reads the CSV rows written by -timeseries until the end of
+// the run.
+func read_intervals(r io.Reader, interval func(*agent_interval)) {
+	cr := csv.NewReader(r)
+	cr.Read() // header
+	for {
+		row, err := cr.Read()
+		if err != nil {
+			return
+		}
+		iv := &agent_interval{}
+		iv.Elapsed, _ = strconv.ParseFloat(row[0], 64)
+		iv.Requests, _ = strconv.ParseInt(row[1], 10, 64)
+		iv.Errors, _ = strconv.ParseInt(row[2], 10, 64)
+		iv.Throughput, _ = strconv.ParseFloat(row[3], 64)
+		iv.P99, _ = strconv.ParseFloat(row[6], 64)
+		interval(iv)
+	}
+}
+
+func (a *agent) stop(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cmd == nil {
+		http.Error(w, "No run in progress", http.StatusConflict)
+		return
+	}
+	// Like ^C, this stops the run gracefully
+	if err := a.cmd.Process.Signal(os.Interrupt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Run stopped by %s", r.RemoteAddr)
+	fmt.Fprintln(w, "Stopping")
+}
+
+func (a *agent) status(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	st := struct {
+		Running bool      `json:"running"`
+		Args    []string  `json:"args,omitempty"`
+		Since   time.Time `json:"since,omitzero"`
+	}{Running: a.cmd != nil}
+	if st.Running {
+		st.Args, st.Since = a.args, a.since
+	}
+	a.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// agent_main implements "hammer agent -listen :9999 -token TOKEN".
+func agent_main(args []string) int {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:9999", "Address to listen to for coordinator requests (e.g. :9999 for all interfaces)")
+	token := fs.String("token", os.Getenv("HAMMER_AGENT_TOKEN"), "Token that coordinator requests must carry (default $HAMMER_AGENT_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s agent -token TOKEN [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "The agent requires a -token (or $HAMMER_AGENT_TOKEN)")
+		return 1
+	}
+	log.Printf("Agent listening on %s", *listen)
+	if err := http.ListenAndServe(*listen, (&agent{token: *token}).handler()); err != nil {
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
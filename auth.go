@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// bearer_token holds the token sent in the Authorization header of each
+// request, replaced while the run goes on when it rotates.
+type bearer_token struct {
+	value atomic.Pointer[string]
+}
+
+func (t *bearer_token) get() string {
+	return *t.value.Load()
+}
+
+func (t *bearer_token) set(token string) {
+	t.value.Store(&token)
+}
+
+// read_token returns the token stored in a file.
+func read_token(file string) (string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", errorString("Empty token file " + file)
+	}
+	return token, nil
+}
+
+// reload reads the token file again every period, keeping the previous
+// token if it cannot be read.
+func (t *bearer_token) reload(file string, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop_ch:
+			return
+		}
+		token, err := read_token(file)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		t.set(token)
+	}
+}
+
+// oauth2_client fetches access tokens with the OAuth2 client credentials
+// grant.
+type oauth2_client struct {
+	client    *http.Client
+	token_url string
+	id        string
+	secret    string
+	scopes    string // space-separated
+}
+
+// fetch returns a new access token and its lifetime, 0 if unknown.
+func (o *oauth2_client) fetch() (string, time.Duration, error) {
+	form := neturl.Values{"grant_type": {"client_credentials"}}
+	if o.scopes != "" {
+		form.Set("scope", o.scopes)
+	}
+	req, err := http.NewRequest("POST", o.token_url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(neturl.QueryEscape(o.id), neturl.QueryEscape(o.secret))
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr); err != nil && resp.StatusCode == http.StatusOK {
+		return "", 0, fmt.Errorf("Token endpoint response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.AccessToken == "" {
+		return "", 0, fmt.Errorf("Token endpoint returned %s %s", resp.Status, tr.Error)
+	}
+	return tr.AccessToken, time.Duration(tr.ExpiresIn) * time.Second, nil
+}
+
+// refresh fetches a new token into t when 80% of the lifetime of the
+// current one has elapsed, retrying every 5 seconds after failures.
+func (o *oauth2_client) refresh(t *bearer_token, lifetime time.Duration) {
+	wait := lifetime * 8 / 10
+	for lifetime > 0 {
+		select {
+		case <-time.After(wait):
+		case <-stop_ch:
+			return
+		}
+		token, d, err := o.fetch()
+		if err != nil {
+			log.Println(err)
+			wait = 5 * time.Second
+			continue
+		}
+		t.set(token)
+		lifetime, wait = d, d*8/10
+	}
+}
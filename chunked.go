@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// chunk_reader reads a request body at most size bytes at a time, pausing
+// delay before each read but the first, so that net/http sends it as
+// chunks of that size spaced by delay.
+type chunk_reader struct {
+	r     io.ReadSeeker
+	size  int
+	delay time.Duration
+}
+
+func (c *chunk_reader) Read(p []byte) (int, error) {
+	if c.delay > 0 {
+		if pos, _ := c.r.Seek(0, io.SeekCurrent); pos > 0 && !pause(c.delay) {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	return c.r.Read(p[:min(len(p), c.size)])
+}
+
+func (c *chunk_reader) Close() error { return nil }
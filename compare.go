@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// read_report loads the results of a run saved with -output json.
+func read_report(name string) (*report, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &report{}
+	if err := json.NewDecoder(f).Decode(r); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return r, nil
+}
+
+// comparison is one line of the comparison of two runs.
+type comparison struct {
+	name          string
+	old, new      float64
+	higher_better bool
+}
+
+// change returns the relative change between the runs, in percent.
+func (c *comparison) change() float64 {
+	if c.old == 0 {
+		return 0
+	}
+	return (c.new - c.old) * 100 / c.old
+}
+
+// regressed tells whether the new run is worse than the old one by more
+// than tolerance percent.
+func (c *comparison) regressed(tolerance float64) bool {
+	if c.higher_better {
+		return c.change() < -tolerance
+	}
+	return c.change() > tolerance
+}
+
+// compare_reports returns the comparison lines of two runs.
+func compare_reports(before, after *report) []comparison {
+	cmp := []comparison{
+		{"throughput (tps)", before.Throughput, after.Throughput, true},
+		{"mean latency (ms)", before.Latency.Mean, after.Latency.Mean, false},
+	}
+	for _, p := range report_percentiles {
+		cmp = append(cmp, comparison{fmt.Sprintf("p%g latency (ms)", p), before.Latency.percentile(p), after.Latency.percentile(p), false})
+	}
+	cmp = append(cmp, comparison{"max latency (ms)", before.Latency.Max, after.Latency.Max, false})
+	return cmp
+}
+
+// print_comparison writes the comparison of two runs and returns whether
+// any figure regressed beyond tolerance.
+func print_comparison(w io.Writer, before, after *report, tolerance percent) bool {
+	regression := false
+	fmt.Fprintf(w, "%-20s %12s %12s %10s\n", "", "old", "new", "change")
+	for _, c := range compare_reports(before, after) {
+		status := ""
+		if c.regressed(float64(tolerance)) {
+			status = "  REGRESSION"
+			regression = true
+		}
+		fmt.Fprintf(w, "%-20s %12.3f %12.3f %+9.2f%%%s\n", c.name, c.old, c.new, c.change(), status)
+	}
+	// Error rates are compared in absolute percentage points
+	old_rate, new_rate := error_rate(before), error_rate(after)
+	status := ""
+	if new_rate-old_rate > float64(tolerance) {
+		status = "  REGRESSION"
+		regression = true
+	}
+	fmt.Fprintf(w, "%-20s %11.3f%% %11.3f%% %+8.2fpt%s\n", "error rate", old_rate, new_rate, new_rate-old_rate, status)
+	return regression
+}
+
+// compare_main implements "hammer compare old.json new.json". It returns
+// the process exit status: 2 if a regression was found.
+func compare_main(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	tolerance := percent(5)
+	fs.Var(&tolerance, "tolerance", "Relative change tolerated before reporting a regression")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s compare [options] old.json new.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+	before, err := read_report(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	after, err := read_report(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if print_comparison(os.Stdout, before, after, tolerance) {
+		return 2
+	}
+	return 0
+}
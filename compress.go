@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// encodings is the -compress flag: the content codings accepted, gzip if
+// set without a value.
+type encodings []string
+
+func (e *encodings) String() string {
+	return strings.Join(*e, ",")
+}
+
+func (e *encodings) Set(value string) error {
+	switch value {
+	case "true":
+		*e = encodings{"gzip"}
+		return nil
+	case "false":
+		*e = nil
+		return nil
+	}
+	*e = nil
+	for _, enc := range strings.Split(value, ",") {
+		switch enc = strings.TrimSpace(enc); enc {
+		case "gzip", "deflate":
+			*e = append(*e, enc)
+		case "br", "zstd":
+			return fmt.Errorf("Content coding %q is not supported: the standard library cannot decode it (gzip or deflate)", enc)
+		default:
+			return fmt.Errorf("Unsupported content coding %q (gzip or deflate)", enc)
+		}
+	}
+	return nil
+}
+
+func (e *encodings) IsBoolFlag() bool { return true }
+
+// decoder returns a reader of the decoded content of r, encoded with enc.
+func decoder(enc string, r io.Reader) (io.Reader, error) {
+	switch enc {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// Normally zlib wrapped, but some servers send raw deflate
+		br := bufio.NewReader(r)
+		if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (int(h[0])<<8|int(h[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, fmt.Errorf("Unsupported content coding %q", enc)
+}
+
+// encoding_count holds the figures of the responses with one content coding.
+type encoding_count struct {
+	responses int64
+	wire      int64 // encoded bytes
+	decoded   int64 // decoded bytes
+}
+
+// compression_report compares the bytes received on the wire with the
+// decoded ones, for the responses with a content coding.
+type compression_report struct {
+	Encodings    map[string]int64 `json:"responses_per_encoding"`
+	WireBytes    int64            `json:"wire_bytes"`
+	DecodedBytes int64            `json:"decoded_bytes"`
+	Ratio        float64          `json:"ratio"`
+}
+
+func new_compression_report(counts map[string]*encoding_count) *compression_report {
+	r := &compression_report{Encodings: make(map[string]int64)}
+	for enc, c := range counts {
+		r.Encodings[enc] = c.responses
+		r.WireBytes += c.wire
+		r.DecodedBytes += c.decoded
+	}
+	if r.WireBytes > 0 {
+		r.Ratio = float64(r.DecodedBytes) / float64(r.WireBytes)
+	}
+	return r
+}
+
+// print_compression writes the compression block of the summary.
+func print_compression(w io.Writer, r *compression_report, requests int64) {
+	if r == nil {
+		return
+	}
+	var encoded int64
+	var names []string
+	for enc, n := range r.Encodings {
+		encoded += n
+		names = append(names, fmt.Sprintf("%s %d", enc, n))
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "Compression: %d of %d responses encoded", encoded, requests)
+	if len(names) != 0 {
+		fmt.Fprintf(w, " (%s), %d bytes on the wire, %d decoded, ratio %.2f", strings.Join(names, ", "), r.WireBytes, r.DecodedBytes, r.Ratio)
+	}
+	fmt.Fprintln(w)
+}
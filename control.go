@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// control is the local HTTP endpoint controlling a run in progress:
+//
+//	POST /pause             stop sending requests until resumed
+//	POST /resume            resume sending requests
+//	POST /stop              end the run, with results as for ^C
+//	POST /rate?rps=N        change the request rate
+//	POST /concurrency?n=N   change the number of active connections
+//	GET  /status            tell whether the run is paused, its rate and
+//	                        concurrency
+//
+// Requests must carry the -control-token in an Authorization: Bearer header.
+type control struct {
+	mu      sync.Mutex
+	j       *job
+	workers int // started workers, the highest concurrency possible
+	paused  bool
+	since   time.Time // beginning of the pause
+}
+
+func new_control(j *job, workers int) *control {
+	return &control{j: j, workers: workers}
+}
+
+// serve starts the HTTP listener of the control endpoint, on the loopback
+// interface if addr is a port alone (:PORT).
+func (c *control) serve(addr, token string) {
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", authorized(token, only("POST", c.pause)))
+	mux.HandleFunc("/resume", authorized(token, only("POST", c.resume)))
+	mux.HandleFunc("/stop", authorized(token, only("POST", c.stop)))
+	mux.HandleFunc("/rate", authorized(token, only("POST", c.post_rate)))
+	mux.HandleFunc("/concurrency", authorized(token, only("POST", c.post_concurrency)))
+	mux.HandleFunc("/status", authorized(token, only("GET", c.status)))
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, mux))
+	}()
+}
+
+func (c *control) pause(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		c.paused, c.since = true, time.Now()
+		c.j.gate.pause(true)
+		log.Printf("Run paused by %s", r.RemoteAddr)
+	}
+	fmt.Fprintln(w, "Paused")
+}
+
+func (c *control) resume(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		c.paused = false
+		// Requests missed during the pause are not sent in a burst
+		if c.j.pacer != nil {
+			c.j.pacer.restart()
+		}
+		c.j.gate.pause(false)
+		log.Printf("Run resumed by %s after %s", r.RemoteAddr, time.Since(c.since).Truncate(time.Millisecond))
+	}
+	fmt.Fprintln(w, "Resumed")
+}
+
+func (c *control) stop(w http.ResponseWriter, r *http.Request) {
+	interrupted.Store(true)
+	stop_workers()
+	log.Printf("Run stopped by %s", r.RemoteAddr)
+	fmt.Fprintln(w, "Stopping")
+}
+
+func (c *control) status(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	st := struct {
+		Paused      bool      `json:"paused"`
+		Since       time.Time `json:"paused_since,omitzero"`
+		Rate        float64   `json:"rate,omitempty"`
+		Concurrency int       `json:"concurrency"`
+	}{Paused: c.paused, Concurrency: c.j.gate.get()}
+	if c.paused {
+		st.Since = c.since
+	}
+	if c.j.pacer != nil {
+		st.Rate = c.j.pacer.rate()
+	}
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// set_rate changes the request rate of a paced run.
+func (c *control) set_rate(rate float64) error {
+	if c.j.pacer == nil {
+		return errorString("The run has no rate to change (closed loop)")
+	}
+	if rate <= 0 {
+		return errorString("Rate must be positive")
+	}
+	c.j.pacer.set_rate(rate)
+	log.Printf("Rate set to %.2f rps", rate)
+	return nil
+}
+
+// set_concurrency changes the number of active workers.
+func (c *control) set_concurrency(n int) error {
+	if n < 1 || n > c.workers {
+		return fmt.Errorf("Concurrency must be between 1 and %d (-max-concurrency)", c.workers)
+	}
+	c.j.gate.set(n)
+	log.Printf("Concurrency set to %d", n)
+	return nil
+}
+
+func (c *control) post_rate(w http.ResponseWriter, r *http.Request) {
+	rate, err := strconv.ParseFloat(r.FormValue("rps"), 64)
+	if err == nil {
+		err = c.set_rate(rate)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "Rate set to %g rps\n", rate)
+}
+
+func (c *control) post_concurrency(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.FormValue("n"))
+	if err == nil {
+		err = c.set_concurrency(n)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "Concurrency set to %d\n", n)
+}
+
+// adjust raises or lowers the load by step percent: the rate of a paced
+// run, the concurrency otherwise.
+func (c *control) adjust(up bool, step percent) error {
+	factor := 1 + float64(step)/100
+	if !up {
+		factor = 1 / factor
+	}
+	if c.j.pacer != nil {
+		return c.set_rate(c.j.pacer.rate() * factor)
+	}
+	cur := c.j.gate.get()
+	n := int(math.Round(float64(cur) * factor))
+	// Change by one connection at least
+	if up {
+		n = max(n, cur+1)
+	} else {
+		n = min(n, cur-1)
+	}
+	return c.set_concurrency(min(max(n, 1), c.workers))
+}
+
+// handle_signals adjusts the load on SIGUSR1 (up) and SIGUSR2 (down).
+func (c *control) handle_signals(step percent) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for s := range sig {
+			if err := c.adjust(s == syscall.SIGUSR1, step); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+}
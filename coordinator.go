@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Options of a run divided between agents, with the default value to divide
+// when not set (only the number of connections, as setting -requests
+// changes the meaning of -duration)
+var split_options = map[string]string{
+	"concurrency":  "100",
+	"requests":     "",
+	"rate":         "",
+	"max-inflight": "",
+}
+
+// split_args returns the options of the share of agent i out of n: the
+// load options (number of connections, requests, rate...) are divided
+// between agents, the other options are passed as is.
+func split_args(args []string, i, n int) ([]string, error) {
+	values := make(map[string]string)
+	var out []string
+	for k := 0; k < len(args); k++ {
+		name, value, inline := strings.Cut(strings.TrimLeft(args[k], "-"), "=")
+		if _, ok := split_options[name]; !ok || !strings.HasPrefix(args[k], "-") {
+			out = append(out, args[k])
+			continue
+		}
+		if !inline {
+			if k++; k == len(args) {
+				return nil, fmt.Errorf("Missing value of option -%s", name)
+			}
+			value = args[k]
+		}
+		values[name] = value
+	}
+	for name, def := range split_options {
+		value, ok := values[name]
+		if !ok {
+			if def == "" {
+				continue
+			}
+			value = def
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %q of option -%s", value, name)
+		}
+		if v == 0 {
+			continue
+		}
+		var share string
+		if name == "rate" {
+			share = strconv.FormatFloat(v/float64(n), 'g', -1, 64)
+		} else {
+			// The remainder goes to the first agents
+			q, r := int(v)/n, int(v)%n
+			if i < r {
+				q++
+			}
+			share = strconv.Itoa(max(q, 1))
+		}
+		out = append(out, "-"+name, share)
+	}
+	return out, nil
+}
+
+func agent_url(addr, path string) string {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/") + path
+}
+
+// run_agent posts a run to an agent and returns its results, passing the
+// interval statistics streamed meanwhile to interval.
+func run_agent(addr, token string, spec run_spec, interval func(*agent_interval)) (*report, error) {
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := post_agent(addr, "/run", token, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var m agent_message
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				err = errorString("Run ended without results")
+			}
+			return nil, err
+		}
+		switch {
+		case m.Error != "":
+			return nil, errorString(m.Error)
+		case m.Result != nil:
+			return m.Result, nil
+		case m.Interval != nil:
+			interval(m.Interval)
+		}
+	}
+}
+
+// post_agent posts a request to an agent, with its token.
+func post_agent(addr, path, token, content_type string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", agent_url(addr, path), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", content_type)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(req)
+}
+
+// stop_agents asks the agents to stop their run gracefully.
+func stop_agents(addrs []string, token string) {
+	for _, addr := range addrs {
+		resp, err := post_agent(addr, "/stop", token, "text/plain", nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Agent %s: %v\n", addr, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// merged_progress prints the interval statistics of all the agents, once
+// each of them has sent those of the interval.
+type merged_progress struct {
+	mu        sync.Mutex
+	w         io.Writer
+	agents    int
+	intervals map[int][]*agent_interval // by interval number
+}
+
+func (p *merged_progress) add(iv *agent_interval) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := int(iv.Elapsed + 0.5)
+	p.intervals[n] = append(p.intervals[n], iv)
+	if len(p.intervals[n]) < p.agents {
+		return
+	}
+	var requests, errors int64
+	var tps, p99 float64
+	for _, a := range p.intervals[n] {
+		requests += a.Requests
+		errors += a.Errors
+		tps += a.Throughput
+		p99 = max(p99, a.P99)
+	}
+	delete(p.intervals, n)
+	fmt.Fprintf(p.w, "[%6.1fs] %d requests, %.2f rps, %d errors, highest agent p99 %.3f ms\n", iv.Elapsed, requests, tps, errors, p99)
+}
+
+// agent_report holds the share of the results of one agent.
+type agent_report struct {
+	Agent      string  `json:"agent"`
+	Requests   int64   `json:"requests"`
+	Errors     int64   `json:"errors"`
+	Throughput float64 `json:"throughput_tps"`
+	P99        float64 `json:"p99_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// merge_reports merges the results of the agents into the results of the
+// whole run, and the statistics they are built from.
+func merge_reports(agents []string, reports []*report, errs []error) (*report, *stats) {
+	st := new_stats()
+	var cfg *report_config
+	var begin, end time.Time
+	var shares []agent_report
+	rep_interrupted := false
+	for i, r := range reports {
+		if errs[i] != nil {
+			shares = append(shares, agent_report{Agent: agents[i], Error: errs[i].Error()})
+			continue
+		}
+		shares = append(shares, agent_report{agents[i], r.Requests, r.Errors, r.Throughput, r.Latency.percentile(99), ""})
+		rep_interrupted = rep_interrupted || r.Interrupted
+		st.requests += r.Requests
+		st.errors += r.Errors
+		st.bytes += r.Bytes
+		st.redirects += r.Redirects
+		for kind, n := range r.ErrorKinds {
+			st.failures[kind] += n
+		}
+		for code, n := range r.StatusCodes {
+			st.codes[code] += n
+		}
+		if r.Histograms != nil {
+			st.latency.add_data(r.Histograms.Latency)
+			st.ttfb.add_data(r.Histograms.TTFB)
+		}
+		r_end := r.Start.Add(time.Duration(r.Duration * float64(time.Second)))
+		if cfg == nil {
+			cfg = &report_config{}
+			*cfg = r.Config
+			cfg.Concurrency, cfg.Requests, cfg.Rate, cfg.MaxInflight = 0, 0, 0, 0
+			begin, end = r.Start, r_end
+		}
+		cfg.Concurrency += r.Config.Concurrency
+		cfg.Requests += r.Config.Requests
+		cfg.Rate += r.Config.Rate
+		cfg.MaxInflight += r.Config.MaxInflight
+		if r.Start.Before(begin) {
+			begin = r.Start
+		}
+		if r_end.After(end) {
+			end = r_end
+		}
+	}
+	rep := new_report(*cfg, st, begin, end)
+	rep.Agents = shares
+	rep.Interrupted = rep_interrupted
+	return rep, st
+}
+
+// print_agents writes the per-agent block of the summary.
+func print_agents(w io.Writer, agents []agent_report) {
+	if len(agents) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Agents:\n  %-24s %10s %8s %12s %10s\n", "agent", "requests", "errors", "tps", "p99 ms")
+	for _, a := range agents {
+		if a.Error != "" {
+			fmt.Fprintf(w, "  %-24s failed: %s\n", a.Agent, a.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  %-24s %10d %8d %12.2f %10.3f\n", a.Agent, a.Requests, a.Errors, a.Throughput, a.P99)
+	}
+}
+
+// coordinator_main implements "hammer coordinator -agents a:9999,b:9999
+// [options] -- [run options]": the run is divided between the agents, which
+// start at the same time, and their results are merged.
+func coordinator_main(args []string) int {
+	fs := flag.NewFlagSet("coordinator", flag.ExitOnError)
+	agents := fs.String("agents", "", "Comma separated addresses of the agents (host:port)")
+	delay := fs.Duration("start-delay", 2*time.Second, "Delay before the synchronized start, for agents to get ready (agent clocks must be synchronized)")
+	output := fs.String("output", "text", "Results format (text or json)")
+	progress := fs.Bool("progress", false, "Print the statistics of all agents every second on the standard error")
+	token := fs.String("token", os.Getenv("HAMMER_AGENT_TOKEN"), "Token of the agents (default $HAMMER_AGENT_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s coordinator -agents HOST:PORT,... [options] -- [run options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *agents == "" || (*output != "text" && *output != "json") {
+		fs.Usage()
+		return 1
+	}
+	addrs := strings.Split(*agents, ",")
+	run_args := fs.Args()
+
+	// ^C stops the agents, which return partial results
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		fmt.Fprintln(os.Stderr, "Stopping agents...")
+		stop_agents(addrs, *token)
+	}()
+
+	mp := &merged_progress{w: os.Stderr, agents: len(addrs), intervals: make(map[int][]*agent_interval)}
+	interval := func(iv *agent_interval) {
+		if *progress {
+			mp.add(iv)
+		}
+	}
+	start := time.Now().Add(*delay)
+	reports := make([]*report, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		share, err := split_args(run_args, i, len(addrs))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reports[i], errs[i] = run_agent(addr, *token, run_spec{Args: share, Start: start}, interval)
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Agent %s failed: %v\n", addrs[i], err)
+			failed++
+		}
+	}
+	if failed == len(addrs) {
+		return 1
+	}
+	rep, st := merge_reports(addrs, reports, errs)
+	if *output == "json" {
+		if err := write_json(os.Stdout, rep); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		write_text(os.Stdout, rep, st)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"strings"
+)
+
+// shell_words splits a command line as a POSIX shell would, with single
+// and double quotes, backslash escapes and line continuations, but no
+// expansion.
+func shell_words(s string) ([]string, error) {
+	var words []string
+	var w strings.Builder
+	in_word := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			if s[i] != '\n' {
+				w.WriteByte(s[i])
+				in_word = true
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errorString("Unterminated ' quote")
+			}
+			w.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			in_word = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				w.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, errorString("Unterminated \" quote")
+			}
+			in_word = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if in_word {
+				words = append(words, w.String())
+				w.Reset()
+				in_word = false
+			}
+		default:
+			w.WriteByte(c)
+			in_word = true
+		}
+	}
+	if in_word {
+		words = append(words, w.String())
+	}
+	return words, nil
+}
+
+// Options of curl translated by -from-curl, by the number of arguments
+// they take: those with none are flags, possibly grouped (-sSL).
+var (
+	curl_flags = map[string]string{
+		"-k": "insecure", "--insecure": "insecure",
+		"-L": "location", "--location": "location",
+		"--compressed": "compressed", "-I": "head", "--head": "head",
+		"-G": "get", "--get": "get", "--http2": "http2",
+		// Ignored: output and HTTP/1 settings
+		"-s": "", "--silent": "", "-S": "", "--show-error": "", "-v": "", "--verbose": "",
+		"-i": "", "--include": "", "-f": "", "--fail": "", "--fail-with-body": "",
+		"-#": "", "--progress-bar": "", "-N": "", "--no-buffer": "", "-g": "", "--globoff": "",
+		"--http1.1": "",
+	}
+	curl_options = map[string]string{
+		"-X": "request", "--request": "request", "-H": "header", "--header": "header",
+		"-d": "data", "--data": "data", "--data-ascii": "data", "--data-binary": "data-binary",
+		"--data-raw": "data-raw", "--data-urlencode": "data-urlencode", "--json": "json",
+		"-F": "form", "--form": "form", "-u": "user", "--user": "user",
+		"-A": "user-agent", "--user-agent": "user-agent", "-e": "referer", "--referer": "referer",
+		"-b": "cookie", "--cookie": "cookie", "--url": "url", "-m": "max-time", "--max-time": "max-time",
+		"--cacert": "cacert", "-E": "cert", "--cert": "cert", "--key": "key",
+		"-x": "proxy", "--proxy": "proxy", "-U": "proxy-user", "--proxy-user": "proxy-user",
+		// Ignored
+		"-o": "", "--output": "", "-w": "", "--write-out": "", "--connect-timeout": "", "--retry": "",
+	}
+)
+
+// curl_data returns the value of a data option, read from a file (or the
+// standard input) if it is @FILE, without its line breaks if strip is set,
+// as curl does for -d but not for --data-binary and --json.
+func curl_data(value string, strip bool) (string, error) {
+	file, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return value, nil
+	}
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return "", err
+	}
+	if strip {
+		return strings.NewReplacer("\r", "", "\n", "").Replace(string(b)), nil
+	}
+	return string(b), nil
+}
+
+// parse_curl translates a curl command line into the hammer options
+// setting the same request, as name and value pairs.
+func parse_curl(command string) ([][2]string, error) {
+	words, err := shell_words(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) > 0 && (words[0] == "curl" || strings.HasSuffix(words[0], "/curl")) {
+		words = words[1:]
+	}
+	var opts [][2]string
+	set := func(name, value string) { opts = append(opts, [2]string{name, value}) }
+	var method, url string
+	var data []string
+	get, json, content_type := false, false, false
+	for i := 0; i < len(words); i++ {
+		arg := words[i]
+		var names []string // of the options in arg
+		value, has_value := "", false
+		switch {
+		case !strings.HasPrefix(arg, "-") || arg == "-":
+			if url != "" {
+				return nil, fmt.Errorf("Several URLs in the curl command: %q and %q", url, arg)
+			}
+			url = arg
+			continue
+		case strings.HasPrefix(arg, "--"):
+			names = []string{arg}
+		default:
+			// -sSL, or -XPOST with its value attached
+			for k := 1; k < len(arg); k++ {
+				name := "-" + arg[k:k+1]
+				names = append(names, name)
+				if _, ok := curl_options[name]; ok && k+1 < len(arg) {
+					value, has_value = arg[k+1:], true
+					break
+				}
+			}
+		}
+		for _, name := range names {
+			if f, ok := curl_flags[name]; ok {
+				switch f {
+				case "insecure":
+					set("insecure", "true")
+				case "location":
+					set("follow-redirects", "true")
+				case "compressed":
+					set("compress", "true")
+				case "head":
+					method = "HEAD"
+				case "get":
+					get = true
+				case "http2":
+					set("http2", "true")
+				}
+				continue
+			}
+			o, ok := curl_options[name]
+			if !ok {
+				return nil, fmt.Errorf("Unsupported curl option %s", name)
+			}
+			if !has_value {
+				if i++; i == len(words) {
+					return nil, fmt.Errorf("Missing value of curl option %s", name)
+				}
+				value = words[i]
+			}
+			switch o {
+			case "request":
+				method = value
+			case "header":
+				set("header", value)
+				content_type = content_type || strings.HasPrefix(strings.ToLower(value), "content-type:")
+			case "data", "data-binary", "json":
+				if value, err = curl_data(value, o == "data"); err != nil {
+					return nil, err
+				}
+				data, json = append(data, value), json || o == "json"
+			case "data-raw":
+				data = append(data, value)
+			case "data-urlencode":
+				if name, v, ok := strings.Cut(value, "="); ok {
+					data = append(data, name+"="+neturl.QueryEscape(v))
+				} else {
+					data = append(data, neturl.QueryEscape(value))
+				}
+			case "form":
+				set("form", value)
+			case "user":
+				user, pass, _ := strings.Cut(value, ":")
+				set("user", user)
+				set("pass", pass)
+			case "user-agent":
+				set("header", "User-Agent: "+value)
+			case "referer":
+				set("header", "Referer: "+value)
+			case "cookie":
+				if !strings.Contains(value, "=") {
+					return nil, fmt.Errorf("Unsupported curl cookie file %q", value)
+				}
+				set("header", "Cookie: "+value)
+			case "url":
+				url = value
+			case "max-time":
+				set("timeout", value+"s")
+			case "cacert", "cert", "key":
+				set(o, value)
+			case "proxy":
+				if !strings.Contains(value, "://") {
+					value = "http://" + value
+				}
+				set("proxy", value)
+			case "proxy-user":
+				user, pass, _ := strings.Cut(value, ":")
+				set("proxy-user", user)
+				set("proxy-pass", pass)
+			}
+		}
+	}
+	if url == "" {
+		return nil, errorString("No URL in the curl command")
+	}
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	body := strings.Join(data, "&")
+	if get && body != "" {
+		if strings.Contains(url, "?") {
+			url += "&" + body
+		} else {
+			url += "?" + body
+		}
+		body = ""
+	}
+	if body != "" {
+		if method == "" {
+			method = "POST"
+		}
+		switch {
+		case json:
+			if !content_type {
+				set("header", "Content-Type: application/json")
+			}
+			set("header", "Accept: application/json")
+		case !content_type:
+			set("header", "Content-Type: application/x-www-form-urlencoded")
+		}
+		if strings.HasPrefix(body, "@") {
+			body = "@" + body // escaped for -body
+		}
+		set("body", body)
+	}
+	if method != "" {
+		set("method", method)
+	}
+	set("url", url)
+	return opts, nil
+}
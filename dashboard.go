@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// dashboard is a live terminal view of the run, redrawn at every sampling
+// interval using ANSI escape sequences.
+type dashboard struct {
+	w      io.Writer
+	target string
+	total  *stats
+}
+
+func new_dashboard(w io.Writer, target string) *dashboard {
+	return &dashboard{w: w, target: target, total: new_stats()}
+}
+
+// sample is the sample_hook redrawing the screen.
+func (d *dashboard) sample(elapsed, interval time.Duration, s *stats) {
+	d.total.merge(s)
+
+	var rps, error_rate float64
+	if interval > 0 {
+		rps = float64(s.requests) / interval.Seconds()
+	}
+	if n := s.requests + s.errors; n > 0 {
+		error_rate = float64(s.errors) * 100 / float64(n)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "hammer %s - %s elapsed\n\n", d.target, elapsed.Truncate(time.Second))
+	fmt.Fprintf(&b, "  Current:  %10.2f rps  %6.2f%% errors\n", rps, error_rate)
+	fmt.Fprintf(&b, "  Total:    %10d requests  %d errors  %d bytes\n\n", d.total.requests, d.total.errors, d.total.bytes)
+
+	fmt.Fprintf(&b, "  Latency (ms)  %10s %10s\n", "current", "overall")
+	for _, p := range report_percentiles {
+		fmt.Fprintf(&b, "    p%-10g %10.3f %10.3f\n", p,
+			usec_to_ms(float64(s.latency.value_at_percentile(p))), usec_to_ms(float64(d.total.latency.value_at_percentile(p))))
+	}
+	fmt.Fprintf(&b, "    %-11s %10.3f %10.3f\n\n", "max", usec_to_ms(float64(s.latency.maximum())), usec_to_ms(float64(d.total.latency.maximum())))
+
+	codes := make([]int, 0, len(d.total.codes))
+	var max int64
+	for code, n := range d.total.codes {
+		codes = append(codes, code)
+		if n > max {
+			max = n
+		}
+	}
+	sort.Ints(codes)
+	fmt.Fprintln(&b, "  Status codes")
+	for _, code := range codes {
+		n := d.total.codes[code]
+		fmt.Fprintf(&b, "    %d %10d %s\n", code, n, bar(n, max, 40))
+	}
+	for _, kind := range error_kinds {
+		if n := d.total.failures[kind]; n != 0 {
+			fmt.Fprintf(&b, "    %-20s %10d\n", kind, n)
+		}
+	}
+	d.w.Write(b.Bytes())
+}
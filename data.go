@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// feeder hands out the rows of a -data CSV file, whose first line names the
+// columns, to the requests or to the virtual users (workers) in turn. Once
+// all the rows were used, it starts over, or stops the requests.
+type feeder struct {
+	mu       sync.Mutex
+	columns  map[string]int
+	rows     [][]string
+	next     int
+	recycle  bool
+	per_user bool // one row per virtual user rather than per request
+}
+
+// read_data reads a -data CSV file.
+func read_data(file string) (*feeder, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s: no data row after the column names", file)
+	}
+	d := &feeder{columns: make(map[string]int), rows: records[1:]}
+	for i, name := range records[0] {
+		d.columns[strings.TrimSpace(name)] = i
+	}
+	return d, nil
+}
+
+// row returns the next row, or false if all were used and are not
+// recycled.
+func (d *feeder) row() ([]string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.next == len(d.rows) {
+		if !d.recycle {
+			return nil, false
+		}
+		d.next = 0
+	}
+	d.next++
+	return d.rows[d.next-1], true
+}
+
+// user_row returns the row of virtual user id, or false if there are fewer
+// rows than users and they are not recycled.
+func (d *feeder) user_row(id int) ([]string, bool) {
+	if id >= len(d.rows) && !d.recycle {
+		return nil, false
+	}
+	return d.rows[id%len(d.rows)], true
+}
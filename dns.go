@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	neturl "net/url"
+	"strings"
+)
+
+// DNS query types supported by dns:// URLs
+var dns_types = map[string]uint16{"A": 1, "AAAA": 28, "SRV": 33}
+
+// DNS response codes reported as errors
+var dns_rcodes = map[int]string{1: "FORMERR", 2: "SERVFAIL", 3: "NXDOMAIN", 4: "NOTIMP", 5: "REFUSED"}
+
+// dns_error reports a DNS response with a non zero response code.
+type dns_error struct {
+	rcode int
+}
+
+func (e *dns_error) Error() string {
+	if name, ok := dns_rcodes[e.rcode]; ok {
+		return "DNS response code " + name
+	}
+	return fmt.Sprintf("DNS response code %d", e.rcode)
+}
+
+// dns_query is a DNS question, sent over UDP with a new ID each time.
+type dns_query struct {
+	packet []byte // query with a zero ID
+}
+
+// new_dns_target returns the target of a dns://server[:port]/name?type=A
+// URL (type A, AAAA or SRV, A by default), or nil for other URLs. Queries
+// go straight to the server, without /etc/hosts, search domains or cache.
+func new_dns_target(u string) (*raw_target, error) {
+	pu, err := neturl.Parse(u)
+	if err != nil || pu.Scheme != "dns" {
+		return nil, err
+	}
+	name := strings.Trim(pu.Path, "/")
+	if pu.Host == "" || name == "" {
+		return nil, fmt.Errorf("DNS URL %q must be dns://server/name", u)
+	}
+	qtype := "A"
+	if t := pu.Query().Get("type"); t != "" {
+		qtype = strings.ToUpper(t)
+	}
+	if dns_types[qtype] == 0 {
+		return nil, fmt.Errorf("Unsupported DNS query type %q (A, AAAA or SRV)", qtype)
+	}
+	addr := pu.Host
+	if pu.Port() == "" {
+		addr = net.JoinHostPort(pu.Hostname(), "53")
+	}
+	q := &dns_query{packet: make([]byte, 12, 12+len(name)+6)}
+	binary.BigEndian.PutUint16(q.packet[2:], 0x0100) // recursion desired
+	binary.BigEndian.PutUint16(q.packet[4:], 1)      // one question
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("Invalid DNS name %q", name)
+		}
+		q.packet = append(q.packet, byte(len(label)))
+		q.packet = append(q.packet, label...)
+	}
+	q.packet = append(q.packet, 0)
+	q.packet = binary.BigEndian.AppendUint16(q.packet, dns_types[qtype])
+	q.packet = binary.BigEndian.AppendUint16(q.packet, 1) // class IN
+	return &raw_target{addr: addr, dns: q}, nil
+}
+
+// exchange sends the query on conn and waits for the response with the
+// same ID, returning its size.
+func (q *dns_query) exchange(conn net.Conn) (int64, error) {
+	id := uint16(rand.Intn(1 << 16))
+	packet := append([]byte(nil), q.packet...)
+	binary.BigEndian.PutUint16(packet, id)
+	if _, err := conn.Write(packet); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		// Skip malformed packets and late responses to earlier queries
+		if n < 12 || binary.BigEndian.Uint16(buf) != id || buf[2]&0x80 == 0 {
+			continue
+		}
+		if rcode := int(buf[3] & 0x0f); rcode != 0 {
+			return int64(n), &dns_error{rcode}
+		}
+		return int64(n), nil
+	}
+}
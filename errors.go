@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Error categories reported in the summary
+const (
+	err_refused         = "connection refused"
+	err_connect_timeout = "connect timeout"
+	err_read_timeout    = "read timeout"
+	err_reset           = "connection reset"
+	err_tls             = "TLS failure"
+	err_pin             = "pin mismatch"
+	err_downgrade       = "TLS downgrade"
+	err_graphql         = "GraphQL errors"
+	err_extract         = "extraction failed"
+	err_sign            = "signing failed"
+	err_schema          = "schema mismatch"
+	err_nxdomain        = "DNS NXDOMAIN"
+	err_servfail        = "DNS SERVFAIL"
+	err_dns             = "DNS error"
+	err_other           = "other"
+)
+
+// error_kinds lists the error categories in display order.
+var error_kinds = []string{err_refused, err_connect_timeout, err_read_timeout, err_reset, err_tls, err_pin, err_downgrade, err_graphql, err_extract, err_sign, err_schema, err_nxdomain, err_servfail, err_dns, err_other}
+
+// classify_error returns the category of an error returned while sending a
+// request or reading its response.
+func classify_error(err error) string {
+	var op *net.OpError
+	var ne net.Error
+	var rh tls.RecordHeaderError
+	var alert tls.AlertError
+	var verif *tls.CertificateVerificationError
+	var unknown_ca x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var gql *graphql_error
+	var dns *dns_error
+	var pin pin_error
+	var down downgrade_error
+	var extract extract_error
+	var schema schema_error
+	var sign sign_error
+
+	switch {
+	case errors.As(err, &gql):
+		return err_graphql
+	case errors.As(err, &extract):
+		return err_extract
+	case errors.As(err, &schema):
+		return err_schema
+	case errors.As(err, &sign):
+		return err_sign
+	case errors.As(err, &dns) && dns.rcode == 3:
+		return err_nxdomain
+	case errors.As(err, &dns) && dns.rcode == 2:
+		return err_servfail
+	case errors.As(err, &dns):
+		return err_dns
+	case errors.As(err, &pin):
+		return err_pin
+	case errors.As(err, &down):
+		return err_downgrade
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return err_refused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return err_reset
+	case errors.As(err, &rh), errors.As(err, &alert), errors.As(err, &verif),
+		errors.As(err, &unknown_ca), errors.As(err, &hostname), errors.As(err, &invalid),
+		strings.Contains(err.Error(), "TLS handshake"), strings.Contains(err.Error(), "remote error: tls"):
+		return err_tls
+	case errors.As(err, &ne) && ne.Timeout():
+		if errors.As(err, &op) && op.Op == "dial" {
+			return err_connect_timeout
+		}
+		return err_read_timeout
+	}
+	return err_other
+}
+
+var logged_kinds = make(map[string]bool)
+var logged_mu sync.Mutex
+
+// log_error logs err if it is the first error of its category, so that a
+// failing target does not flood the log.
+func log_error(kind string, err error) {
+	logged_mu.Lock()
+	defer logged_mu.Unlock()
+	if !logged_kinds[kind] {
+		logged_kinds[kind] = true
+		log.Printf("%s: %v", kind, err)
+	}
+}
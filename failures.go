@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// failure_log saves the first non-2xx responses (status line, headers and
+// body) for debugging. It is shared by all workers.
+type failure_log struct {
+	mu   sync.Mutex
+	w    io.Writer
+	left atomic.Int64
+}
+
+func new_failure_log(w io.Writer, max int) *failure_log {
+	fl := &failure_log{w: w}
+	fl.left.Store(int64(max))
+	return fl
+}
+
+// save writes resp to the log if the quota of saved responses is not
+// exhausted yet. The response body is read, then replaced with a copy.
+func (fl *failure_log) save(req *http.Request, resp *http.Response) {
+	if fl.left.Add(-1) < 0 {
+		return
+	}
+	dump, err := httputil.DumpResponse(resp, true)
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fmt.Fprintf(fl.w, "### %s %s %s at %s\n", req.Method, req.URL, resp.Status, time.Now().Format(time.RFC3339Nano))
+	if err != nil {
+		fmt.Fprintf(fl.w, "(dump failed: %v)\n", err)
+	}
+	fl.w.Write(dump)
+	fmt.Fprintln(fl.w)
+}
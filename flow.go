@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// extractor sets a variable of the virtual user from a response, for the
+// {{.NAME}} placeholders of the next requests of a -flow. It is declared by
+// an extract key of the request, NAME SOURCE:EXPRESSION, the source being:
+//
+//	json    dotted path in the JSON body (data.items.0.id, $. prefix optional)
+//	regex   regular expression matched in the body, its first group if any
+//	header  response header name
+type extractor struct {
+	name   string
+	source string
+	expr   string
+	re     *regexp.Regexp
+}
+
+func parse_extractor(spec string) (extractor, error) {
+	name, rest, _ := strings.Cut(strings.TrimSpace(spec), " ")
+	source, expr, ok := strings.Cut(strings.TrimSpace(rest), ":")
+	x := extractor{name: name, source: source, expr: expr}
+	if name == "" || !ok || expr == "" {
+		return x, fmt.Errorf("extract must be NAME json:PATH, NAME regex:EXPRESSION or NAME header:NAME, not %q", spec)
+	}
+	switch source {
+	case "json":
+		x.expr = strings.TrimPrefix(strings.TrimPrefix(expr, "$"), ".")
+	case "regex":
+		var err error
+		if x.re, err = regexp.Compile(expr); err != nil {
+			return x, err
+		}
+	case "header":
+	default:
+		return x, fmt.Errorf("Unknown extract source %q, must be json, regex or header", source)
+	}
+	return x, nil
+}
+
+// extract_error is the failure to extract a variable from a response.
+type extract_error string
+
+func (e extract_error) Error() string {
+	return "Nothing to extract for " + string(e)
+}
+
+// extract_vars sets vars from the response and body of a request of endpoint e.
+func (e *endpoint) extract_vars(vars map[string]string, resp *http.Response, body []byte) error {
+	for _, x := range e.extract {
+		var value string
+		var ok bool
+		switch x.source {
+		case "json":
+			value, ok = json_path(body, x.expr)
+		case "regex":
+			if m := x.re.FindSubmatch(body); m != nil {
+				value, ok = string(m[min(1, len(m)-1)]), true
+			}
+		case "header":
+			value = resp.Header.Get(x.expr)
+			ok = value != ""
+		}
+		if !ok {
+			return extract_error(x.name + " in " + e.name)
+		}
+		vars[x.name] = value
+	}
+	return nil
+}
+
+// json_path returns the value at a dotted path of a JSON document: object
+// members by name and array elements by index. Strings are returned as is,
+// other values in JSON.
+func json_path(doc []byte, path string) (string, bool) {
+	var v any
+	if json.Unmarshal(doc, &v) != nil {
+		return "", false
+	}
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch node := v.(type) {
+			case map[string]any:
+				var ok bool
+				if v, ok = node[key]; !ok {
+					return "", false
+				}
+			case []any:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(node) {
+					return "", false
+				}
+				v = node[i]
+			default:
+				return "", false
+			}
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	b, _ := json.Marshal(v)
+	return string(b), v != nil
+}
+
+// new_vars returns the variables of a virtual user of the scenario, empty
+// until extracted.
+func (s *scenario) new_vars() map[string]string {
+	vars := make(map[string]string)
+	for _, e := range s.endpoints {
+		for _, x := range e.extract {
+			vars[x.name] = ""
+		}
+	}
+	return vars
+}
+
+// uses_body tells whether extracting the variables of e, or checking its
+// responses against an OpenAPI specification, needs the response body.
+func (e *endpoint) uses_body() bool {
+	if e.op != nil && e.op.validate {
+		return true
+	}
+	for _, x := range e.extract {
+		if x.source != "header" {
+			return true
+		}
+	}
+	return false
+}
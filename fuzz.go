@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Named charsets of fuzzed values
+var fuzz_charsets = map[string]string{
+	"digits": "0123456789",
+	"lower":  "abcdefghijklmnopqrstuvwxyz",
+	"upper":  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alpha":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alnum":  "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"hex":    "0123456789abcdef",
+}
+
+// fuzzer draws the random values of a query parameter or a path segment,
+// from its KEY=SPEC flag, SPEC being:
+//
+//	A..B             integer between A and B included
+//	CHARSET:N        N characters of CHARSET: digits, lower, upper, alpha,
+//	                 alnum, hex, or else the characters listed
+//	CHARSET:MIN-MAX  between MIN and MAX characters of CHARSET
+type fuzzer struct {
+	key     string
+	segment int    // number of the path segment, from 1
+	lo, hi  int64  // integer range, or length range with a charset
+	charset []rune // nil for integers
+}
+
+func parse_fuzzer(value string) (fuzzer, error) {
+	key, spec, ok := strings.Cut(value, "=")
+	x := fuzzer{key: key}
+	if !ok || key == "" {
+		return x, fmt.Errorf("Fuzzing format must be KEY=A..B, KEY=CHARSET:N or KEY=CHARSET:MIN-MAX, not %q", value)
+	}
+	bounds := func(a, b string) (err error) {
+		if x.lo, err = strconv.ParseInt(a, 10, 64); err != nil {
+			return err
+		}
+		if x.hi, err = strconv.ParseInt(b, 10, 64); err != nil {
+			return err
+		}
+		if x.lo > x.hi {
+			return fmt.Errorf("Empty fuzzing range in %q", value)
+		}
+		return nil
+	}
+	if i := strings.LastIndexByte(spec, ':'); i >= 0 {
+		charset, length := spec[:i], spec[i+1:]
+		if named, ok := fuzz_charsets[charset]; ok {
+			charset = named
+		}
+		if x.charset = []rune(charset); len(x.charset) == 0 {
+			return x, fmt.Errorf("Empty fuzzing charset in %q", value)
+		}
+		lo, hi, found := strings.Cut(length, "-")
+		if !found {
+			hi = lo
+		}
+		if err := bounds(lo, hi); err != nil {
+			return x, err
+		}
+		if x.lo < 0 {
+			return x, fmt.Errorf("Negative fuzzing length in %q", value)
+		}
+		return x, nil
+	}
+	a, b, found := strings.Cut(spec, "..")
+	if !found {
+		return x, fmt.Errorf("Fuzzing range must be A..B, CHARSET:N or CHARSET:MIN-MAX, not %q", spec)
+	}
+	return x, bounds(a, b)
+}
+
+// value draws a value.
+func (x *fuzzer) value() string {
+	n := x.lo + rand.Int63n(x.hi-x.lo+1)
+	if x.charset == nil {
+		return strconv.FormatInt(n, 10)
+	}
+	s := make([]rune, n)
+	for i := range s {
+		s[i] = x.charset[rand.Intn(len(x.charset))]
+	}
+	return string(s)
+}
+
+// fuzz_query type: the -fuzz-query parameters, implementing the flag.Value
+// interface.
+type fuzz_query []fuzzer
+
+func (f *fuzz_query) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *fuzz_query) Set(value string) error {
+	x, err := parse_fuzzer(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, x)
+	return nil
+}
+
+// fuzz_path type: the -fuzz-path segments, implementing the flag.Value
+// interface.
+type fuzz_path []fuzzer
+
+func (f *fuzz_path) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *fuzz_path) Set(value string) error {
+	x, err := parse_fuzzer(value)
+	if err != nil {
+		return err
+	}
+	if x.segment, err = strconv.Atoi(x.key); err != nil || x.segment < 1 {
+		return fmt.Errorf("Fuzzed path segment must be a number from 1, not %q", x.key)
+	}
+	*f = append(*f, x)
+	sort.SliceStable(*f, func(i, j int) bool { return (*f)[i].segment < (*f)[j].segment })
+	return nil
+}
+
+// url_fuzzer varies the URL of each request, to defeat caches and stress
+// routing with many distinct keys.
+type url_fuzzer struct {
+	query fuzz_query
+	path  fuzz_path
+}
+
+// apply returns a copy of u with random values of its fuzzed path segments,
+// the segments past the last one of u being appended, and with the fuzzed
+// query parameters appended.
+func (f *url_fuzzer) apply(u *neturl.URL) *neturl.URL {
+	v := *u
+	if len(f.path) > 0 {
+		segments := strings.Split(strings.TrimPrefix(u.EscapedPath(), "/"), "/")
+		for _, x := range f.path {
+			value := neturl.PathEscape(x.value())
+			if x.segment <= len(segments) {
+				segments[x.segment-1] = value
+			} else {
+				segments = append(segments, value)
+			}
+		}
+		v.RawPath = "/" + strings.Join(segments, "/")
+		v.Path, _ = neturl.PathUnescape(v.RawPath)
+	}
+	for _, x := range f.query {
+		if v.RawQuery != "" {
+			v.RawQuery += "&"
+		}
+		v.RawQuery += neturl.QueryEscape(x.key) + "=" + neturl.QueryEscape(x.value())
+	}
+	return &v
+}
@@ -0,0 +1,124 @@
+package main
+
+import "sync"
+
+// gate limits the number of active workers: worker i may only send
+// requests while i < active, and none while paused. Workers beyond the
+// limit wait until it is raised or the run is stopped.
+type gate struct {
+	mu      sync.Mutex
+	active  int
+	paused  bool
+	changed chan bool // closed when active or paused changes
+}
+
+func new_gate(active int) *gate {
+	return &gate{active: active, changed: make(chan bool)}
+}
+
+// set changes the number of active workers.
+func (g *gate) set(active int) {
+	g.mu.Lock()
+	g.active = active
+	close(g.changed)
+	g.changed = make(chan bool)
+	g.mu.Unlock()
+}
+
+// pause stops or resumes all the workers.
+func (g *gate) pause(paused bool) {
+	g.mu.Lock()
+	g.paused = paused
+	close(g.changed)
+	g.changed = make(chan bool)
+	g.mu.Unlock()
+}
+
+// closed tells whether worker i may not send requests at the moment.
+func (g *gate) closed(i int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return i >= g.active || g.paused
+}
+
+// get returns the number of active workers.
+func (g *gate) get() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.active
+}
+
+// wait blocks until worker i is active. It returns false if workers were
+// asked to stop meanwhile.
+func (g *gate) wait(i int) bool {
+	for {
+		g.mu.Lock()
+		if i < g.active && !g.paused {
+			g.mu.Unlock()
+			return true
+		}
+		changed := g.changed
+		g.mu.Unlock()
+		select {
+		case <-changed:
+		case <-stop_ch:
+			return false
+		}
+	}
+}
+
+// budget is the number of requests left to send, shared by the workers of
+// runs whose concurrency varies: workers beyond the active ones may never
+// get their share. Pipelined batches reserve requests, and give back those
+// left unanswered. The run stops once a worker finds nothing left and
+// nothing reserved.
+type budget struct {
+	mu       sync.Mutex
+	left     int
+	reserved int
+	settled  chan bool // closed when reserved requests are settled
+}
+
+func new_budget(n int) *budget {
+	return &budget{left: n, settled: make(chan bool)}
+}
+
+// take takes up to n requests, reserving them if reserve is set, and
+// returns how many it got. With nothing left, it waits for the reserved
+// requests to be settled.
+func (b *budget) take(n int, reserve bool) int {
+	for {
+		b.mu.Lock()
+		if b.left > 0 || b.reserved == 0 {
+			break
+		}
+		settled := b.settled
+		b.mu.Unlock()
+		select {
+		case <-settled:
+		case <-stop_ch:
+			return 0
+		}
+	}
+	n = min(n, b.left)
+	b.left -= n
+	if reserve {
+		b.reserved += n
+	}
+	b.mu.Unlock()
+	if n == 0 {
+		stop_workers()
+	}
+	return n
+}
+
+// settle releases reserved requests, of which sent were answered or
+// failed, giving back the others.
+func (b *budget) settle(reserved, sent int) {
+	b.mu.Lock()
+	b.reserved -= reserved
+	b.left += reserved - sent
+	close(b.settled)
+	b.settled = make(chan bool)
+	b.mu.Unlock()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// graphql_body returns the POST body of a GraphQL request, with the query
+// and the variables (JSON, optional) read from files.
+func graphql_body(query_file, vars_file string) (string, error) {
+	query, err := os.ReadFile(query_file)
+	if err != nil {
+		return "", err
+	}
+	req := struct {
+		Query     string          `json:"query"`
+		Variables json.RawMessage `json:"variables,omitempty"`
+	}{Query: string(query)}
+	if vars_file != "" {
+		if req.Variables, err = os.ReadFile(vars_file); err != nil {
+			return "", err
+		}
+		if !json.Valid(req.Variables) {
+			return "", fmt.Errorf("%s: invalid JSON", vars_file)
+		}
+	}
+	b, err := json.Marshal(req)
+	return string(b), err
+}
+
+// graphql_error reports a GraphQL response with errors.
+type graphql_error struct {
+	count   int
+	message string // of the first error
+}
+
+func (e *graphql_error) Error() string {
+	return fmt.Sprintf("GraphQL response with %d errors, first: %s", e.count, e.message)
+}
+
+// check_graphql returns a graphql_error if the response body has a non
+// empty errors array, whatever the HTTP status.
+func check_graphql(body []byte) error {
+	var resp struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &resp) != nil || len(resp.Errors) == 0 {
+		return nil
+	}
+	return &graphql_error{len(resp.Errors), resp.Errors[0].Message}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gRPC status code names, by code
+var grpc_status_names = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+func grpc_status_name(code int) string {
+	if 0 <= code && code < len(grpc_status_names) {
+		return grpc_status_names[code]
+	}
+	return strconv.Itoa(code)
+}
+
+// grpc_status returns the gRPC status of a response, sent as a trailer or,
+// for responses without a body, as a header. It returns -1 if missing.
+func grpc_status(resp *http.Response) int {
+	s := resp.Trailer.Get("Grpc-Status")
+	if s == "" {
+		s = resp.Header.Get("Grpc-Status")
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return code
+}
+
+// grpc_status_counts returns the response counts by gRPC status name.
+func grpc_status_counts(codes map[int]int64) map[string]int64 {
+	if len(codes) == 0 {
+		return nil
+	}
+	counts := make(map[string]int64)
+	for code, n := range codes {
+		if code < 0 {
+			counts["missing"] += n
+		} else {
+			counts[grpc_status_name(code)] += n
+		}
+	}
+	return counts
+}
+
+// print_grpc_status writes the gRPC status block of the summary.
+func print_grpc_status(w io.Writer, codes map[int]int64) {
+	if len(codes) == 0 {
+		return
+	}
+	sorted := make([]int, 0, len(codes))
+	for code := range codes {
+		sorted = append(sorted, code)
+	}
+	sort.Ints(sorted)
+	fmt.Fprintln(w, "gRPC status codes:")
+	for _, code := range sorted {
+		name := grpc_status_name(code)
+		if code < 0 {
+			name = "missing"
+		}
+		fmt.Fprintf(w, "  %-20s %10d\n", name, codes[code])
+	}
+}
+
+// Protocol buffers wire types
+const (
+	wire_varint  = 0
+	wire_fixed64 = 1
+	wire_bytes   = 2
+	wire_fixed32 = 5
+)
+
+// Field types of FieldDescriptorProto
+const (
+	pb_double   = 1
+	pb_float    = 2
+	pb_int64    = 3
+	pb_uint64   = 4
+	pb_int32    = 5
+	pb_fixed64  = 6
+	pb_fixed32  = 7
+	pb_bool     = 8
+	pb_string   = 9
+	pb_message  = 11
+	pb_bytes    = 12
+	pb_uint32   = 13
+	pb_enum     = 14
+	pb_sfixed32 = 15
+	pb_sfixed64 = 16
+	pb_sint32   = 17
+	pb_sint64   = 18
+)
+
+// pb_field is the part of a FieldDescriptorProto needed to encode it.
+type pb_field struct {
+	name, json_name string
+	number          int
+	typ             int
+	repeated        bool
+	type_name       string // fully qualified, with a leading dot
+}
+
+type pb_message_type struct {
+	fields []*pb_field
+}
+
+// proto_set holds the message, enum and method types of a descriptor set
+// (protoc --include_imports --descriptor_set_out=FILE), by full name.
+type proto_set struct {
+	messages map[string]*pb_message_type
+	enums    map[string]map[string]int
+	methods  map[string][2]string // input and output types
+}
+
+// pb_reader iterates over the fields of an encoded message.
+type pb_reader struct {
+	b []byte
+}
+
+// next returns the number, wire type and value of the next field, value
+// being the varint itself or the bytes of a length-delimited field.
+func (r *pb_reader) next() (num int, wire int, v uint64, data []byte, err error) {
+	key, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		return 0, 0, 0, nil, errorString("Invalid descriptor set")
+	}
+	r.b = r.b[n:]
+	num, wire = int(key>>3), int(key&7)
+	switch wire {
+	case wire_varint:
+		if v, n = binary.Uvarint(r.b); n <= 0 {
+			return 0, 0, 0, nil, errorString("Invalid descriptor set")
+		}
+		r.b = r.b[n:]
+	case wire_fixed64, wire_fixed32:
+		size := 8
+		if wire == wire_fixed32 {
+			size = 4
+		}
+		if len(r.b) < size {
+			return 0, 0, 0, nil, errorString("Invalid descriptor set")
+		}
+		r.b = r.b[size:]
+	case wire_bytes:
+		if v, n = binary.Uvarint(r.b); n <= 0 || uint64(len(r.b)-n) < v {
+			return 0, 0, 0, nil, errorString("Invalid descriptor set")
+		}
+		data, r.b = r.b[n:n+int(v)], r.b[n+int(v):]
+	default:
+		return 0, 0, 0, nil, fmt.Errorf("Unsupported wire type %d in descriptor set", wire)
+	}
+	return
+}
+
+// each calls f for every field of an encoded message.
+func pb_each(b []byte, f func(num int, v uint64, data []byte) error) error {
+	r := &pb_reader{b}
+	for len(r.b) > 0 {
+		num, _, v, data, err := r.next()
+		if err != nil {
+			return err
+		}
+		if err := f(num, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read_proto_set loads a binary FileDescriptorSet.
+func read_proto_set(name string) (*proto_set, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	ps := &proto_set{
+		messages: make(map[string]*pb_message_type),
+		enums:    make(map[string]map[string]int),
+		methods:  make(map[string][2]string),
+	}
+	err = pb_each(b, func(num int, _ uint64, file []byte) error {
+		if num != 1 {
+			return nil
+		}
+		return ps.add_file(file)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return ps, nil
+}
+
+// add_file adds the types of a FileDescriptorProto.
+func (ps *proto_set) add_file(b []byte) error {
+	var pkg string
+	var messages, enums, services [][]byte
+	err := pb_each(b, func(num int, _ uint64, data []byte) error {
+		switch num {
+		case 2:
+			pkg = string(data)
+		case 4:
+			messages = append(messages, data)
+		case 5:
+			enums = append(enums, data)
+		case 6:
+			services = append(services, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	scope := ""
+	if pkg != "" {
+		scope = "." + pkg
+	}
+	for _, m := range messages {
+		if err := ps.add_message(scope, m); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := ps.add_enum(scope, e); err != nil {
+			return err
+		}
+	}
+	for _, s := range services {
+		if err := ps.add_service(pkg, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add_message adds a DescriptorProto and its nested types.
+func (ps *proto_set) add_message(scope string, b []byte) error {
+	mt := &pb_message_type{}
+	var name string
+	var nested, enums [][]byte
+	err := pb_each(b, func(num int, _ uint64, data []byte) error {
+		switch num {
+		case 1:
+			name = string(data)
+		case 2:
+			f := &pb_field{}
+			err := pb_each(data, func(num int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					f.name = string(data)
+				case 3:
+					f.number = int(v)
+				case 4:
+					f.repeated = v == 3 // LABEL_REPEATED
+				case 5:
+					f.typ = int(v)
+				case 6:
+					f.type_name = string(data)
+				case 10:
+					f.json_name = string(data)
+				}
+				return nil
+			})
+			mt.fields = append(mt.fields, f)
+			return err
+		case 3:
+			nested = append(nested, data)
+		case 4:
+			enums = append(enums, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	full := scope + "." + name
+	ps.messages[full] = mt
+	for _, m := range nested {
+		if err := ps.add_message(full, m); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := ps.add_enum(full, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add_enum adds an EnumDescriptorProto.
+func (ps *proto_set) add_enum(scope string, b []byte) error {
+	values := make(map[string]int)
+	var name string
+	err := pb_each(b, func(num int, _ uint64, data []byte) error {
+		switch num {
+		case 1:
+			name = string(data)
+		case 2:
+			var vname string
+			var vnum int
+			err := pb_each(data, func(num int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					vname = string(data)
+				case 2:
+					vnum = int(int32(v))
+				}
+				return nil
+			})
+			values[vname] = vnum
+			return err
+		}
+		return nil
+	})
+	ps.enums[scope+"."+name] = values
+	return err
+}
+
+// add_service adds the methods of a ServiceDescriptorProto, by
+// "package.Service/Method" name.
+func (ps *proto_set) add_service(pkg string, b []byte) error {
+	var name string
+	var methods [][]byte
+	err := pb_each(b, func(num int, _ uint64, data []byte) error {
+		switch num {
+		case 1:
+			name = string(data)
+		case 2:
+			methods = append(methods, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if pkg != "" {
+		name = pkg + "." + name
+	}
+	for _, m := range methods {
+		var method, in, out string
+		err := pb_each(m, func(num int, _ uint64, data []byte) error {
+			switch num {
+			case 1:
+				method = string(data)
+			case 2:
+				in = string(data)
+			case 3:
+				out = string(data)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		ps.methods[name+"/"+method] = [2]string{in, out}
+	}
+	return nil
+}
+
+// encode_json encodes a JSON document as a message of type msg.
+func (ps *proto_set) encode_json(msg string, doc string) ([]byte, error) {
+	var v map[string]any
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+	if strings.TrimSpace(doc) != "" {
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+	}
+	var buf []byte
+	return ps.encode_message(buf, msg, v)
+}
+
+func (ps *proto_set) encode_message(buf []byte, msg string, v map[string]any) ([]byte, error) {
+	mt := ps.messages[msg]
+	if mt == nil {
+		return nil, fmt.Errorf("Unknown message type %s", strings.TrimPrefix(msg, "."))
+	}
+	for key, value := range v {
+		var f *pb_field
+		for _, mf := range mt.fields {
+			if mf.name == key || mf.json_name == key {
+				f = mf
+				break
+			}
+		}
+		if f == nil {
+			return nil, fmt.Errorf("Unknown field %q of %s", key, strings.TrimPrefix(msg, "."))
+		}
+		values := []any{value}
+		if f.repeated {
+			list, ok := value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("Field %q must be an array", key)
+			}
+			values = list
+		}
+		for _, item := range values {
+			var err error
+			if buf, err = ps.encode_field(buf, f, item); err != nil {
+				return nil, fmt.Errorf("Field %q: %v", key, err)
+			}
+		}
+	}
+	return buf, nil
+}
+
+func pb_key(buf []byte, num, wire int) []byte {
+	return binary.AppendUvarint(buf, uint64(num)<<3|uint64(wire))
+}
+
+// encode_field appends one value of field f.
+func (ps *proto_set) encode_field(buf []byte, f *pb_field, v any) ([]byte, error) {
+	if f.typ == pb_message {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, errorString("value must be an object")
+		}
+		sub, err := ps.encode_message(nil, f.type_name, obj)
+		if err != nil {
+			return nil, err
+		}
+		buf = pb_key(buf, f.number, wire_bytes)
+		buf = binary.AppendUvarint(buf, uint64(len(sub)))
+		return append(buf, sub...), nil
+	}
+	if f.typ == pb_string || f.typ == pb_bytes {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errorString("value must be a string")
+		}
+		data := []byte(s)
+		if f.typ == pb_bytes {
+			var err error
+			if data, err = base64.StdEncoding.DecodeString(s); err != nil {
+				return nil, errorString("value must be base64 encoded")
+			}
+		}
+		buf = pb_key(buf, f.number, wire_bytes)
+		buf = binary.AppendUvarint(buf, uint64(len(data)))
+		return append(buf, data...), nil
+	}
+
+	// Scalars, given as JSON numbers, booleans, enum names or strings
+	// (for 64-bit integers)
+	var n json.Number
+	switch x := v.(type) {
+	case json.Number:
+		n = x
+	case bool:
+		n = "0"
+		if x {
+			n = "1"
+		}
+	case string:
+		if f.typ == pb_enum {
+			e, ok := ps.enums[f.type_name][x]
+			if !ok {
+				return nil, fmt.Errorf("unknown value %q of %s", x, strings.TrimPrefix(f.type_name, "."))
+			}
+			n = json.Number(strconv.Itoa(e))
+		} else {
+			n = json.Number(x)
+		}
+	default:
+		return nil, errorString("value must be a number")
+	}
+	switch f.typ {
+	case pb_double, pb_float:
+		d, err := n.Float64()
+		if err != nil {
+			return nil, err
+		}
+		if f.typ == pb_float {
+			return binary.LittleEndian.AppendUint32(pb_key(buf, f.number, wire_fixed32), math.Float32bits(float32(d))), nil
+		}
+		return binary.LittleEndian.AppendUint64(pb_key(buf, f.number, wire_fixed64), math.Float64bits(d)), nil
+	}
+	i, err := strconv.ParseInt(string(n), 10, 64)
+	if err != nil {
+		u, uerr := strconv.ParseUint(string(n), 10, 64)
+		if uerr != nil {
+			return nil, err
+		}
+		i = int64(u)
+	}
+	switch f.typ {
+	case pb_int64, pb_uint64, pb_int32, pb_uint32, pb_bool, pb_enum:
+		return binary.AppendUvarint(pb_key(buf, f.number, wire_varint), uint64(i)), nil
+	case pb_sint32, pb_sint64:
+		return binary.AppendUvarint(pb_key(buf, f.number, wire_varint), uint64(i<<1^i>>63)), nil
+	case pb_fixed32, pb_sfixed32:
+		return binary.LittleEndian.AppendUint32(pb_key(buf, f.number, wire_fixed32), uint32(i)), nil
+	case pb_fixed64, pb_sfixed64:
+		return binary.LittleEndian.AppendUint64(pb_key(buf, f.number, wire_fixed64), uint64(i)), nil
+	}
+	return nil, fmt.Errorf("unsupported field type %d", f.typ)
+}
+
+// grpc_request returns the body of a unary call of method with the JSON
+// message doc: the encoded message behind the 5-byte gRPC frame header.
+// Without a descriptor set, doc is sent as is, as the encoded message.
+func grpc_request(ps *proto_set, method, doc string) (string, error) {
+	msg := []byte(doc)
+	if ps != nil {
+		types, ok := ps.methods[method]
+		if !ok {
+			return "", fmt.Errorf("Unknown method %s in the descriptor set", method)
+		}
+		var err error
+		if msg, err = ps.encode_json(types[0], doc); err != nil {
+			return "", err
+		}
+	}
+	var b bytes.Buffer
+	b.WriteByte(0) // not compressed
+	binary.Write(&b, binary.BigEndian, uint32(len(msg)))
+	b.Write(msg)
+	return b.String(), nil
+}
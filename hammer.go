@@ -1,16 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
+	neturl "net/url"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -45,25 +56,300 @@ func (h *header) Set(value string) error {
 	return nil
 }
 
+// byte_size is a flag.Value holding a number of bytes, written with an
+// optional decimal (KB, MB, GB, TB) or binary (KiB, MiB, GiB, TiB) unit.
+type byte_size int64
+
+var byte_units = []struct {
+	suffix string
+	factor float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+}
+
+func (b *byte_size) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byte_size) Set(value string) error {
+	value = strings.TrimSpace(value)
+	factor := 1.0
+	for _, u := range byte_units {
+		if strings.HasSuffix(value, u.suffix) {
+			value, factor = strings.TrimSpace(strings.TrimSuffix(value, u.suffix)), u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 {
+		return errorString("Size format must be `10GB' or `512MiB'")
+	}
+	*b = byte_size(v * factor)
+	return nil
+}
+
 var ready_ch = make(chan bool)
 var start_ch = make(chan bool)
 var done_ch = make(chan bool)
 
-func send_requests(client *http.Client, iter int, method string, url string, body string, hdr header, user string, pass string) {
-	var body_reader io.ReadSeeker
-	if 0 < len(body) {
-		body_reader = strings.NewReader(body)
+// stop_ch is closed to tell workers to stop sending requests
+var stop_ch = make(chan bool)
+var stop_once sync.Once
+
+// stop_workers asks all workers to stop after their current request.
+func stop_workers() {
+	stop_once.Do(func() { close(stop_ch) })
+}
+
+// abort_reason tells why the run was stopped early, if it was
+var abort_reason atomic.Value
+
+// abort_run stops the workers early, recording why unless the run was
+// already aborted.
+func abort_run(reason string) {
+	abort_reason.CompareAndSwap(nil, reason)
+	stop_workers()
+}
+
+// interrupted is set when the run was stopped by a signal
+var interrupted atomic.Bool
+
+// handle_signals stops the workers on the first SIGINT or SIGTERM, so that
+// the statistics collected so far can be reported, and exits immediately
+// on the second one.
+func handle_signals() {
+	sig_ch := make(chan os.Signal, 2)
+	signal.Notify(sig_ch, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sig_ch
+		log.Printf("Received %v, stopping (send again to exit immediately)", sig)
+		interrupted.Store(true)
+		stop_workers()
+		<-sig_ch
+		os.Exit(1)
+	}()
+}
+
+// stopped tells whether workers were asked to stop.
+func stopped() bool {
+	select {
+	case <-stop_ch:
+		return true
+	default:
+		return false
 	}
-	req, err := http.NewRequest(method, url, body_reader)
+}
+
+// pause waits for d, or until workers are asked to stop, in which case it
+// returns false.
+func pause(d time.Duration) bool {
+	if d <= 0 {
+		return !stopped()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop_ch:
+		return false
+	}
+}
+
+// write_file creates the named file and fills it with write, exiting on
+// failure.
+func write_file(name string, write func(w io.Writer) error) {
+	f, err := os.Create(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// job describes the requests sent by all the workers.
+type job struct {
+	client *http.Client
+	method string
+	url    string
+	body   string
+	hdr    header
+	user   string
+	pass   string
+	pacer  *pacer // nil unless requests are sent at a fixed rate
+	// Rate of each worker's own schedule, 0 unless connections are paced
+	// individually
+	conn_rate float64
+	poisson   bool
+	// Measure latency from the scheduled send time rather than from the
+	// actual one, to correct coordinated omission
+	corrected bool
+	phases    bool         // time DNS, connect, TLS handshake and server wait
+	failures  *failure_log // nil unless non-2xx responses are saved
+	gate      *gate        // nil unless the number of active workers varies
+	budget    *budget      // nil unless the workers share the -requests
+	// Pause between consecutive requests of a worker, plus or minus a
+	// uniformly distributed random jitter
+	think, jitter time.Duration
+	// Each worker uses its own transport, hence keeps its own connection
+	// open even while it waits for an in-flight slot
+	own_conn bool
+	inflight chan bool // nil unless the number of outstanding requests is capped
+	// Response bytes after which the run stops, 0 for no limit
+	max_bytes int64
+	received  atomic.Int64
+	template  bool          // expand the placeholders of each request
+	seq       atomic.Int64  // requests expanded, with template
+	data      *feeder       // nil unless placeholders are fed from -data
+	conns     *conn_tracker // nil unless HTTP/2 connection usage is tracked
+	grpc      bool          // record the gRPC status of responses
+	raw       *raw_target   // nil unless sending raw TCP or TLS payloads
+	graphql   bool          // count responses with GraphQL errors as failed
+	// Send the body with chunked transfer encoding, in chunks of this size
+	// spaced by chunk_delay, if chunk_size is not 0
+	chunk_size  int
+	chunk_delay time.Duration
+	form        form      // nil unless a new multipart body is built per request
+	cookies     bool      // each worker keeps its own cookie jar
+	encodings   encodings // nil unless responses are requested compressed
+	// Send Expect: 100-continue and time the interim response
+	expect_continue bool
+	sticky          *sticky           // nil unless workers send a user identifier
+	affinity        *affinity_tracker // nil unless response backends are tracked
+	tls             *tls_tracker      // nil unless the target uses TLS
+	bearer          *bearer_token     // nil unless requests carry a bearer token
+	sigv4           *sigv4_signer     // nil unless requests are signed for AWS
+	jwt             *jwt_signer       // nil unless requests carry generated JWTs
+	sign_hook       *sign_hook        // nil unless an external command signs requests
+	urls            []*neturl.URL     // cycled through by each worker, with -url-file
+	replay          *replay           // nil unless replaying an access log
+	scenario        *scenario         // nil unless requests are drawn from a -scenario
+	fuzz            *url_fuzzer       // nil unless query parameters or path segments are fuzzed
+	// Set by stages changing the target URL
+	target atomic.Pointer[neturl.URL]
+}
+
+// spend takes up to n requests of the shared budget, if any, and returns
+// how many it got. Reserved requests must be settled.
+func (j *job) spend(n int, reserve bool) int {
+	if j.budget == nil {
+		return n
+	}
+	return j.budget.take(n, reserve)
+}
+
+// think_time returns the next pause of a worker between two requests.
+func (j *job) think_time() time.Duration {
+	d := j.think
+	if j.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*j.jitter)+1)) - j.jitter
+	}
+	return max(d, 0)
+}
+
+// set_body makes body that of req, sent with chunked transfer encoding if
+// configured, and returns its reader.
+func (j *job) set_body(req *http.Request, body string) *strings.Reader {
+	r := strings.NewReader(body)
+	req.Body, req.ContentLength = io.NopCloser(r), int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(body)), nil
+	}
+	if j.chunk_size > 0 {
+		req.Body = &chunk_reader{r, j.chunk_size, j.chunk_delay}
+		req.ContentLength, req.GetBody = -1, nil
+	}
+	return r
+}
+
+// send_requests is the worker goroutine: it sends iter requests (or until
+// stopped if iter < 0), starting delay after the beginning of the run.
+func send_requests(j *job, id int, iter int, delay time.Duration, st *stats) {
+	req, err := http.NewRequest(j.method, j.url, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	for _, hf := range hdr {
+	var body_reader *strings.Reader
+	if 0 < len(j.body) {
+		body_reader = j.set_body(req, j.body)
+	}
+	payload := j.body // current body, for signatures
+	var hash string   // of the body, for AWS signatures
+	if j.sigv4 != nil {
+		hash = payload_hash(payload)
+	}
+	for _, hf := range j.hdr {
 		req.Header.Add(hf.name, hf.value)
 	}
-	if user != "" {
-		req.SetBasicAuth(user, pass)
+	if j.user != "" {
+		req.SetBasicAuth(j.user, j.pass)
+	}
+	var first_byte, wrote_headers, got_continue time.Time
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { first_byte = time.Now() },
+	}
+	if j.expect_continue {
+		req.Header.Set("Expect", "100-continue")
+		trace.WroteHeaders = func() { wrote_headers = time.Now() }
+		trace.Got100Continue = func() { got_continue = time.Now() }
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	client := j.client
+	if j.own_conn || j.cookies {
+		c := *j.client
+		client = &c
+	}
+	if j.own_conn {
+		client.Transport = j.client.Transport.(*http.Transport).Clone()
+	}
+	// The client adds the jar cookies to the request itself: restore its
+	// own Cookie header before each request
+	if j.sticky != nil {
+		j.sticky.set(req, id)
+	}
+	var vars map[string]string // extracted by the -flow steps
+	step := 0                  // next -flow step
+	if j.scenario != nil && j.scenario.flow {
+		vars = j.scenario.new_vars()
+	}
+	var plain, fuzzed *neturl.URL // of the last request, before and after -fuzz-query and -fuzz-path
+	var row []string              // -data row of the virtual user, or of the request
+	if j.data != nil && j.data.per_user {
+		var ok bool
+		if row, ok = j.data.user_row(id); !ok {
+			iter = 0 // no row left for this user
+		}
+	}
+	if j.jwt != nil && j.jwt.per_user && iter != 0 {
+		token, err := j.jwt.token(id, row)
+		if err != nil {
+			log.Println(err)
+			iter = 0 // still reporting ready and done
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	cookie_hdr := req.Header["Cookie"]
+	base_hdr := req.Header // without the headers of scenario endpoints
+	if j.cookies {
+		client.Jar, _ = cookiejar.New(nil)
+	}
+	if j.conns != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), j.conns.trace()))
+	}
+	if j.tls != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), j.tls.trace()))
+	}
+	var pt *phase_timer
+	if j.phases {
+		pt = &phase_timer{}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), pt.trace()))
 	}
 
 	// Tell main thread we are ready
@@ -72,69 +358,898 @@ func send_requests(client *http.Client, iter int, method string, url string, bod
 	// Wait for main thread to start the injection
 	<-start_ch
 
+	// Ramp-up
+	if !pause(delay) {
+		done_ch <- true
+		return
+	}
+
+	pc := j.pacer
+	if j.conn_rate > 0 {
+		// Spread the first requests of the connections over an interval
+		pc = new_pacer(j.conn_rate, j.poisson)
+		pc.start(time.Now().Add(time.Duration(rand.Int63n(int64(pc.interval) + 1))))
+	}
+
 	var buf = make([]byte, 4096)
-	// Perform injection
-	for i := 0; i < iter; i++ {
-		if body_reader != nil {
-			_, err = body_reader.Seek(0, 0)
+	var content bytes.Buffer // response body, when checked
+	// Perform injection (iter < 0 means until stopped)
+	for i := 0; (iter < 0 || i < iter) && !stopped(); i++ {
+		if i > 0 && j.think > 0 && !pause(j.think_time()) {
+			break
+		}
+		if j.gate != nil && !j.gate.wait(id) {
+			break
+		}
+		var scheduled time.Time
+		var ep_st *endpoint_stats // statistics of the endpoint, with a scenario
+		var e *endpoint
+		if j.scenario != nil {
+			ep := step
+			if !j.scenario.flow {
+				ep = j.scenario.pick()
+			}
+			ep_st = j.scenario.stats[ep]
+			e = &j.scenario.endpoints[ep]
+			// Endpoint headers replace those of the job, and can be repeated
+			req.Header = base_hdr.Clone()
+			for _, hf := range e.hdr {
+				req.Header.Del(hf.name)
+			}
+			for _, hf := range e.hdr {
+				req.Header.Add(hf.name, strings.TrimSpace(hf.value))
+			}
+			u, body := e.url, e.body
+			if e.op != nil {
+				u, body = e.op.generate(req.Header)
+			}
+			req.Method, req.URL, req.Host = e.method, u, u.Host
+			req.Body, req.ContentLength, req.GetBody = nil, 0, nil
+			body_reader, payload = nil, body
+			if body != "" {
+				body_reader = j.set_body(req, body)
+			}
+			if j.sigv4 != nil {
+				hash = payload_hash(body)
+			}
+		}
+		if j.template {
+			if j.data != nil && !j.data.per_user {
+				var ok bool
+				if row, ok = j.data.row(); !ok {
+					break
+				}
+			}
+			body, err := j.expand_request(req, e, row, vars)
 			if err != nil {
 				log.Println(err)
 				break
 			}
+			if body != "" {
+				body_reader = j.set_body(req, body)
+			}
+			payload = body
+			if j.sigv4 != nil {
+				hash = payload_hash(body)
+			}
 		}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println(err)
+		if j.cookies {
+			req.Header["Cookie"] = cookie_hdr
+		}
+		if j.bearer != nil {
+			req.Header.Set("Authorization", "Bearer "+j.bearer.get())
+		}
+		if j.jwt != nil && !j.jwt.per_user {
+			token, err := j.jwt.token(id, row)
+			if err != nil {
+				log.Println(err)
+				break
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if len(j.urls) > 0 {
+			u := j.urls[(id+i)%len(j.urls)]
+			req.URL, req.Host = u, u.Host
+		}
+		if j.replay != nil {
+			r, ok := j.replay.take()
+			if !ok {
+				break
+			}
+			req.Method, req.URL, req.Host = r.method, r.url, r.url.Host
+		}
+		if t := j.target.Load(); t != nil && t != req.URL {
+			req.URL, req.Host = t, t.Host
+		}
+		if j.fuzz != nil {
+			if req.URL != fuzzed {
+				plain = req.URL
+			}
+			fuzzed = j.fuzz.apply(plain)
+			req.URL = fuzzed
+		}
+		if pc != nil {
+			scheduled = pc.wait()
+			if stopped() {
+				break
+			}
+			// Drop the slot if the worker was deactivated meanwhile
+			if j.gate != nil && j.gate.closed(id) {
+				i--
+				continue
+			}
+		}
+		if j.spend(1, false) == 0 {
 			break
 		}
-		for {
-			_, err = resp.Body.Read(buf)
+		if j.form != nil {
+			body, content_type := j.form.build()
+			body_reader = j.set_body(req, body)
+			req.Header.Set("Content-Type", content_type)
+			payload = body
+			if j.sigv4 != nil {
+				hash = payload_hash(body)
+			}
+		} else if body_reader != nil {
+			_, err = body_reader.Seek(0, 0)
 			if err != nil {
-				if err != io.EOF {
-					log.Println(err)
+				log.Println(err)
+				break
+			}
+		}
+		if j.sign_hook != nil {
+			if err := j.sign_hook.sign(req, payload); err != nil {
+				if stopped() {
+					break
+				}
+				kind := classify_error(err)
+				log_error(kind, err)
+				st.record_error(kind)
+				if ep_st != nil {
+					ep_st.record_error(kind)
+					step = 0 // start the flow over
+				}
+				if live != nil {
+					live.record_error(kind)
 				}
+				continue
+			}
+		}
+		if j.inflight != nil {
+			select {
+			case j.inflight <- true:
+			case <-stop_ch:
+			}
+			if stopped() {
 				break
 			}
 		}
-		resp.Body.Close()
+		if j.sigv4 != nil {
+			j.sigv4.sign(req, hash, time.Now())
+		}
+		got_continue = time.Time{}
+		sent := time.Now()
+		resp, err := client.Do(req)
+		var size int64
+		if err == nil {
+			if j.failures != nil && resp.StatusCode/100 != 2 {
+				j.failures.save(req, resp)
+			}
+			content.Reset()
+			var body io.Reader = resp.Body
+			var wire *counting_reader
+			enc := resp.Header.Get("Content-Encoding")
+			if j.encodings != nil && enc != "" {
+				wire = &counting_reader{r: resp.Body}
+				body, err = decoder(enc, wire)
+			}
+			for err == nil {
+				var n int
+				n, err = body.Read(buf)
+				size += int64(n)
+				if j.graphql || (e != nil && e.uses_body()) {
+					content.Write(buf[:n])
+				}
+			}
+			resp.Body.Close()
+			if err == io.EOF {
+				err = nil
+			}
+			if err == nil && wire != nil {
+				st.record_encoding(enc, wire.n, size)
+			}
+			if err == nil && j.graphql {
+				err = check_graphql(content.Bytes())
+			}
+			if err == nil && e != nil && len(e.extract) > 0 {
+				err = e.extract_vars(vars, resp, content.Bytes())
+			}
+			if err == nil && e != nil && e.op != nil && e.op.validate {
+				err = e.op.check(resp, content.Bytes())
+			}
+		}
+		if j.inflight != nil {
+			<-j.inflight
+		}
+		if err != nil {
+			kind := classify_error(err)
+			log_error(kind, err)
+			st.record_error(kind)
+			if ep_st != nil {
+				ep_st.record_error(kind)
+				step = 0 // start the flow over
+			}
+			if live != nil {
+				live.record_error(kind)
+			}
+			if pt != nil {
+				pt.record(nil)
+			}
+			continue
+		}
+		if j.corrected {
+			sent = scheduled
+		}
+		latency := time.Since(sent)
+		st.record_latency(latency)
+		st.record_ttfb(first_byte.Sub(sent))
+		if !got_continue.IsZero() {
+			st.record_continue(got_continue.Sub(wrote_headers))
+		}
+		if pt != nil {
+			pt.record(st)
+		}
+		st.record_status(resp.StatusCode)
+		st.record_redirects(redirects(resp))
+		if j.affinity != nil {
+			j.affinity.record(id, resp)
+		}
+		if j.grpc {
+			st.record_grpc_status(grpc_status(resp))
+		}
+		st.record_bytes(size)
+		if ep_st != nil {
+			ep_st.record_response(latency, resp.StatusCode, size)
+			step = (step + 1) % len(j.scenario.endpoints)
+		}
+		if j.conns != nil {
+			j.conns.record_protocol(resp.Proto)
+		}
+		if live != nil {
+			live.record_response(latency, resp.StatusCode, size)
+		}
+		if j.max_bytes > 0 && j.received.Add(size) >= j.max_bytes {
+			stop_workers()
+		}
 	}
 	done_ch <- true
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "compare":
+			os.Exit(compare_main(os.Args[2:]))
+		case "coordinator":
+			os.Exit(coordinator_main(os.Args[2:]))
+		case "agent":
+			os.Exit(agent_main(os.Args[2:]))
+		}
+	}
+
 	// Command line parameters
 	var conc, reqs, cpus int
-	var ka, comp bool
-	var method, url, body, user, pass, cpuprof /*, memprof*/ string
+	var comp encodings
+	var template, ka, insecure, tls_sessions, corrected, per_worker, phases, ui, search, capacity, churn, histograms, http2, sse bool
+	var search_step, target_p99, think, jitter, cooldown, sign_timeout time.Duration
+	search_gain, capacity_precision := percent(5), percent(5)
+	var capacity_max float64
+	var rate, conn_rate float64
+	var duration, timeout, progress_every, ramp, window_size time.Duration
+	var data_file, data_end string
+	var data_per_user bool
+	var replay_file, har_file, har_base, from_curl string
+	var postman_file, postman_env, postman_requests string
+	var openapi_file, openapi_ops, openapi_base string
+	var openapi_validate bool
+	var replay_speed float64
+	var method, url, url_file, scenario_file, flow_file, body, body_file, user, pass, cpuprof /*, memprof*/ string
+	var output, output_file, timeseries_file, hgrm_file, junit_file, metrics_addr string
+	var sink_spec, sink_prefix, control_addr, control_token string
+	var grpc_method, proto_set_file, unix_socket, proxy, proxy_user, proxy_pass, cacert string
+	var client_cert, client_key, ciphers, sni, alpn, keylog, downgrades string
+	var bearer, bearer_file string
+	var oauth2 oauth2_client
+	var aws_sigv4, jwt_key, jwt_claims, sign_cmd string
+	var jwt_ttl time.Duration
+	var jwt_per_user bool
+	var bearer_reload time.Duration
+	var tls_min, tls_max tls_version
+	var pinned pins
+	var payload_file, expect_delim string
+	var expect_bytes, pipeline int
+	var handshake bool
+	var graphql_file, variables_file string
+	var otlp_endpoint, test_name string
+	var otlp_every, soak_every time.Duration
+	var soak_dir string
 	var hdr header
+	var start_at time.Time
+	var limits sla
+	var abort_error_rate percent
+	var save_failures, max_inflight, max_conc int
+	var adjust_step percent
+	var max_bytes, chunk_size byte_size
+	var chunk_delay, expect_continue time.Duration
+	var chunked, form_per_request, cookies bool
+	var form_fields form
+	var fuzz_q fuzz_query
+	var fuzz_p fuzz_path
+	var follow max_redirects
+	var sticky_id sticky
+	var backend_header string
+	var model, arrival, stages_spec, spike_spec, plan_file string
+	var failures_file string
 
-	flag.StringVar(&body, "body", "", "Request body")
+	flag.StringVar(&arrival, "arrival", "uniform", "With -rate, request arrival process: uniform (fixed intervals) or poisson")
+	flag.StringVar(&body, "body", "", "Request body, @FILE to read it from a file, or - to read it from the standard input (@@ for a body starting with a literal @)")
+	flag.StringVar(&body_file, "body-file", "", "Read the request body from this file (- for the standard input)")
+	flag.BoolVar(&churn, "churn", false, "Connection churn: open a new connection for every request and report connection setup throughput and times (implies -keep-alive=false -phases)")
+	flag.StringVar(&control_addr, "control", "", "Listen on ADDR (e.g. :8089 on the loopback interface, 0.0.0.0:8089 on all) for POST /pause, /resume and /stop requests controlling the run")
+	flag.StringVar(&control_token, "control-token", os.Getenv("HAMMER_AGENT_TOKEN"), "Token that -control requests must carry in an Authorization: Bearer header (default $HAMMER_AGENT_TOKEN)")
+	flag.Var(&adjust_step, "adjust-step", "On SIGUSR1 (SIGUSR2), raise (lower) the rate, or the concurrency without -rate, by this percentage")
 	flag.IntVar(&conc, "concurrency", 100, "Number of concurrent connections")
+	flag.DurationVar(&cooldown, "cooldown", 0, "With -stages or -plan, idle gap between stages, excluded from statistics")
 	flag.IntVar(&cpus, "cpus", 2, "Number of CPUs/kernel threads used")
+	flag.BoolVar(&corrected, "co-correct", false, "With -rate, measure latency from the scheduled send time (coordinated omission correction)")
 	flag.StringVar(&cpuprof, "cpu-prof", "", "CPU profile file name (pprof format)")
-	flag.BoolVar(&comp, "compress", false, "Use HTTP compression")
+	flag.DurationVar(&duration, "duration", 0, "Run duration (e.g. 30s, 5m), overrides -requests unless it is set too, the run then ending at whichever limit comes first")
+	flag.Var(&comp, "compress", "Use HTTP compression: accept gzip, or the content codings given as -compress=gzip,deflate (br and zstd are not supported), decode the responses and report compressed and decoded bytes")
+	flag.Var(&abort_error_rate, "abort-error-rate", "Stop the run early if the rate of errors and 5xx responses exceeds this percentage")
+	flag.BoolVar(&http2, "http2", false, "Use HTTP/2 (over TLS for https URLs, cleartext h2c otherwise), multiplexing requests over few connections")
+	flag.StringVar(&graphql_file, "graphql", "", "Send the GraphQL query of this file (POST, JSON), counting responses with errors as failed")
+	flag.StringVar(&variables_file, "variables", "", "With -graphql, JSON file of the query variables")
+	flag.StringVar(&grpc_method, "grpc", "", "Send gRPC unary calls to this method (package.Service/Method) of the -url server, -body being the request message in JSON")
+	flag.StringVar(&proto_set_file, "proto-set", "", "With -grpc, descriptor set of the service (protoc --include_imports --descriptor_set_out), to encode -body; without it, -body is sent as the encoded message")
 	flag.Var(&hdr, "header", "Additional request header (can be set multiple time)")
+	flag.BoolVar(&histograms, "histograms", false, "With -output json, include the full latency histograms (used to merge the results of several runs)")
+	flag.StringVar(&hgrm_file, "hgrm", "", "Write the latency histogram to this file (HdrHistogram .hgrm format, milliseconds)")
+	flag.StringVar(&junit_file, "junit", "", "Write the threshold checks to this file as a JUnit XML report")
 	flag.BoolVar(&ka, "keep-alive", true, "Use HTTP keep-alive")
+	flag.StringVar(&client_cert, "cert", "", "Client certificate (PEM) for mutual TLS, or directory of NAME.pem or NAME.crt certificates with NAME.key keys presented by the connections in turn")
+	flag.StringVar(&client_key, "key", "", "Private key (PEM) of the -cert client certificate, if not in the same file")
+	flag.StringVar(&ciphers, "ciphers", "", "Comma-separated TLS 1.0 to 1.2 cipher suites offered (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256), Go's defaults if not set")
+	flag.StringVar(&alpn, "alpn", "", "Comma-separated ALPN protocols offered (e.g. h2,http/1.1); h2 requires -http2, which also always offers h2 and http/1.1")
+	flag.StringVar(&sni, "sni", "", "TLS server name sent (SNI) and verified, instead of the -url host")
+	flag.BoolVar(&tls_sessions, "tls-sessions", false, "Cache TLS sessions (tickets) so that new connections resume them with a short handshake")
+	flag.StringVar(&keylog, "tls-keylog", "", "Append the TLS secrets to this file in NSS key log format, to decrypt the traffic (e.g. with Wireshark)")
+	flag.Var(&tls_min, "tls-min", "Minimum TLS `version` (1.0 to 1.3)")
+	flag.Var(&tls_max, "tls-max", "Maximum TLS `version` (1.0 to 1.3)")
+	flag.StringVar(&downgrades, "tls-downgrade", "", "Count (warn) or fail (reject) the handshakes negotiating a TLS version below the highest offered or a weak cipher suite (CBC, without forward secrecy or insecure)")
+	flag.StringVar(&cacert, "cacert", "", "Verify the TLS certificates of the server against the CAs of this PEM file rather than the system ones")
+	flag.Var(&pinned, "pin", "Fail handshakes unless a certificate of the server has a public key with this SHA-256 `hash`, written sha256/BASE64 (comma-separated or repeated for several)")
+	flag.BoolVar(&insecure, "insecure", false, "Do not verify the TLS certificates of the server")
 	flag.StringVar(&pass, "pass", "", "HTTP authentication password")
 	//flag.StringVar(&memprof, "mem-prof", "", "Memory allocation profile file name (pprof format)")
+	flag.IntVar(&max_inflight, "max-inflight", 0, "Maximum number of outstanding requests, independently of the number of connections set by -concurrency, 0 for one per connection")
+	flag.DurationVar(&expect_continue, "expect-continue", 0, "Send the body after an Expect: 100-continue interim response, waited for at most this long (e.g. 1s), and report the wait")
+	flag.Var(&sticky_id, "sticky", "Session affinity: each connection keeps its own connection open and sends its identifier in this `cookie:NAME or header:NAME`")
+	flag.StringVar(&backend_header, "backend-header", "", "Report how consistently each connection was served by the backend named in this response header (e.g. X-Served-By)")
+	flag.BoolVar(&cookies, "cookies", false, "Give each connection its own cookie jar, storing the cookies set by responses and sending them with its next requests")
+	flag.Var(&follow, "follow-redirects", "Follow 3xx redirects, up to 10 or N given as -follow-redirects=N, rather than recording them as responses")
+	flag.Var(&fuzz_q, "fuzz-query", "Append to each request a query parameter NAME=A..B (random integer), NAME=CHARSET:N or NAME=CHARSET:MIN-MAX (random string of N, or MIN to MAX, characters of digits, lower, upper, alpha, alnum, hex or the characters listed) (can be set multiple times)")
+	flag.Var(&fuzz_p, "fuzz-path", "Replace path segment N (from 1, appended past the last one) of each request with a random value, N=A..B, N=CHARSET:LEN or N=CHARSET:MIN-MAX as for -fuzz-query (can be set multiple times)")
+	flag.Var(&form_fields, "form", "multipart/form-data field name=value, or file field name=@file (can be set multiple times)")
+	flag.BoolVar(&form_per_request, "form-per-request", false, "With -form, build a new multipart body, with a new boundary, for each request")
+	flag.BoolVar(&chunked, "chunked", false, "Send the request body with chunked transfer encoding rather than Content-Length")
+	flag.Var(&chunk_size, "chunk-size", "With -chunked, size of the body chunks (e.g. 1KB, default 4KB)")
+	flag.DurationVar(&chunk_delay, "chunk-delay", 0, "With -chunked, pause between the body chunks (e.g. 10ms)")
+	flag.Var(&max_bytes, "max-bytes", "Stop the run once this many response bytes were received (e.g. 10GB), whatever -requests or -duration")
+	flag.IntVar(&max_conc, "max-concurrency", 0, "With -control or -adjust-step, maximum concurrency the run can be raised to")
+	flag.DurationVar(&limits.max_mean, "max-mean", 0, "Fail (exit status 2) if the mean latency exceeds this duration")
+	flag.DurationVar(&limits.max_p50, "max-p50", 0, "Fail (exit status 2) if the median latency exceeds this duration")
+	flag.DurationVar(&limits.max_p90, "max-p90", 0, "Fail (exit status 2) if the 90th percentile latency exceeds this duration")
+	flag.DurationVar(&limits.max_p99, "max-p99", 0, "Fail (exit status 2) if the 99th percentile latency exceeds this duration")
+	flag.Var(&limits.max_error_rate, "max-error-rate", "Fail (exit status 2) if the rate of errors and 5xx responses exceeds this percentage")
+	flag.StringVar(&metrics_addr, "metrics", "", "Publish live Prometheus metrics on http://ADDR/metrics (e.g. :9090)")
+	flag.IntVar(&latency_sigfigs, "precision", 3, "Significant digits of latency statistics (1 to 5), lower values use less memory per worker")
 	flag.StringVar(&method, "method", "GET", "HTTP method (GET, POST, PUT, DELETE...)")
+	flag.StringVar(&otlp_endpoint, "otlp", "", "Export metrics to this OpenTelemetry collector OTLP/HTTP endpoint (e.g. http://localhost:4318)")
+	flag.DurationVar(&otlp_every, "otlp-interval", 10*time.Second, "Interval between OTLP metric exports")
+	flag.StringVar(&model, "model", "", "Load model: closed (each connection sends its next request when the previous one completes) or open (requests sent at -rate whatever the latency), by default open if -rate is set")
+	flag.StringVar(&output_file, "o", "", "Write results to this file instead of the standard output")
+	flag.StringVar(&output, "output", "text", "Results format (text or json)")
+	flag.DurationVar(&progress_every, "progress", 0, "Print progress on the standard error at this interval (e.g. 1s), 0 to disable")
+	flag.StringVar(&proxy, "proxy", "", "Send requests through this forward proxy: http://[user:pass@]host:port (https requests being tunneled with CONNECT) or socks5://[user:pass@]host:port")
+	flag.StringVar(&proxy_user, "proxy-user", "", "Proxy authentication user, sent with -proxy-pass as Basic credentials")
+	flag.StringVar(&proxy_pass, "proxy-pass", "", "Proxy authentication password, or Bearer token sent in Proxy-Authorization without -proxy-user")
+	flag.DurationVar(&ramp, "ramp", 0, "Ramp-up time over which workers are started one after the other")
+	flag.BoolVar(&per_worker, "per-worker", false, "Report statistics of each worker (connection) separately")
+	flag.BoolVar(&phases, "phases", false, "Report DNS, connect, TLS handshake and server wait times separately")
+	flag.StringVar(&plan_file, "plan", "", "Run the stages described in this file (TOML [[stage]] tables with name, duration, rate, concurrency and url keys)")
+	flag.Float64Var(&rate, "rate", 0, "Fixed request rate in requests per second (open loop), 0 for as fast as possible")
+	flag.Float64Var(&conn_rate, "rate-per-conn", 0, "Request rate of each connection in requests per second (open loop per connection), instead of a global -rate")
+	flag.Float64Var(&limits.min_throughput, "min-throughput", 0, "Fail (exit status 2) if the average throughput is below this many tps")
 	flag.IntVar(&reqs, "requests", 10000, "Total number of requests")
-	flag.StringVar(&url, "url", "http://127.0.0.1/", "URL")
+	flag.DurationVar(&target_p99, "target-p99", 0, "Adjust the request rate continuously to hold the 99th percentile latency under this duration (starting at -rate if set)")
+	flag.StringVar(&test_name, "test-name", "", "Name of the test, used to tag exported metrics")
+	flag.DurationVar(&think, "think", 0, "Think time: pause of each connection between consecutive requests (e.g. 200ms)")
+	flag.DurationVar(&jitter, "think-jitter", 0, "Random variation of the think time, up to this duration either way")
+	flag.DurationVar(&timeout, "timeout", 0, "Request timeout (e.g. 5s), 0 for none")
+	flag.IntVar(&save_failures, "save-failures", 0, "Save the first N non-2xx responses (status, headers and body) to -failures-file")
+	flag.StringVar(&failures_file, "failures-file", "failures.log", "File where failing responses are saved")
+	flag.StringVar(&sink_spec, "sink", "", "Stream per-second metrics to statsd://HOST:PORT or influx://HOST:PORT (append +tcp to the scheme for TCP)")
+	flag.StringVar(&sink_prefix, "sink-prefix", "hammer", "Metric name prefix (StatsD) or measurement name (InfluxDB) of the sink")
+	flag.BoolVar(&capacity, "capacity", false, "Search the highest rate keeping the 99th percentile latency within -max-p99, with probes of -search-step starting at -rate")
+	flag.Float64Var(&capacity_max, "capacity-max", 0, "With -capacity, highest rate probed, 0 for no limit")
+	flag.Var(&capacity_precision, "capacity-precision", "With -capacity, precision of the rate found")
+	flag.BoolVar(&search, "search", false, "Search the concurrency giving the maximum throughput (up to -concurrency, within -max-p99 if set)")
+	flag.Var(&search_gain, "search-gain", "With -search, minimum throughput gain for doubling the concurrency")
+	flag.DurationVar(&search_step, "search-step", 10*time.Second, "With -search or -capacity, duration of each step")
+	flag.DurationVar(&soak_every, "summary-every", 0, "Soak test: print a summary of the results so far on the standard error at this interval (e.g. 10m)")
+	flag.StringVar(&soak_dir, "summary-dir", "", "With -summary-every, also save each summary (JSON) and the latency histogram of its period (.hgrm) in this directory")
+	flag.BoolVar(&sse, "sse", false, "Server-Sent Events: keep an event stream open per connection until the end of -duration, reconnecting when dropped, and report events received")
+	flag.StringVar(&spike_spec, "spike", "", "Spike test: run at -rate for -duration, with a burst of LOAD:DURATION in the middle (e.g. 1000rps:10s)")
+	flag.StringVar(&stages_spec, "stages", "", "Load profile: comma separated LOAD:DURATION stages, LOAD being Nrps, Nc (active connections) or both (e.g. 50rps:1m,100rps+20c:2m)")
+	flag.StringVar(&timeseries_file, "timeseries", "", "Write per-second statistics to this CSV file")
+	flag.DurationVar(&window_size, "window", 0, "Report peak and minimum throughput over a sliding window of this size (e.g. 10s)")
+	flag.BoolVar(&ui, "ui", false, "Show a live dashboard on the terminal (standard error) during the run")
+	flag.StringVar(&unix_socket, "unix-socket", "", "Connect to this unix domain socket instead of the -url host (also set by a unix:///path/to.sock:/path URL)")
+	flag.BoolVar(&handshake, "handshake", false, "Only connect and perform a full TLS handshake per request, then close (https:// or tls:// URL), to measure handshakes per second")
+	flag.IntVar(&pipeline, "pipeline", 0, "Pipeline this many HTTP/1.1 requests per connection, writing each batch before reading the responses")
+	flag.StringVar(&payload_file, "payload", "", "With a tcp:// or tls:// URL, file of the payload sent on the connection for each request")
+	flag.IntVar(&expect_bytes, "expect-bytes", 0, "With a tcp:// or tls:// URL, wait for a reply of this many bytes after each payload")
+	flag.StringVar(&expect_delim, "expect-delim", "", "With a tcp:// or tls:// URL, wait for a reply ending with this string (Go escapes allowed, e.g. \\r\\n) after each payload")
+	flag.StringVar(&url, "url", "http://127.0.0.1/", "URL, tcp://HOST:PORT or tls://HOST:PORT to send raw -payload data, or dns://SERVER/NAME?type=A|AAAA|SRV to send DNS queries")
+	flag.StringVar(&scenario_file, "scenario", "", "Instead of -url, send the weighted mix of requests described in this file (TOML [[request]] tables with name, weight, method, url, header and body keys) and report each separately")
+	flag.BoolVar(&template, "template", false, "Expand the {{uuid}}, {{randint MIN MAX}}, {{timestamp}}, {{timestamp_ms}} and {{seq}} placeholders of the URL, header values and body for each request")
+	flag.StringVar(&data_file, "data", "", "CSV file, whose first line names the columns, feeding its rows to the {{.NAME}} placeholders of the requests, one row per request (implies -template)")
+	flag.BoolVar(&data_per_user, "data-per-user", false, "Feed one -data row per virtual user (connection) rather than per request")
+	flag.StringVar(&data_end, "data-end", "recycle", "Once all the -data rows were used, start over (recycle) or stop sending requests (stop)")
+	flag.StringVar(&flow_file, "flow", "", "Like -scenario, but each connection sends the requests of this file in order, their extract keys (NAME json:PATH, regex:EXPRESSION or header:NAME) setting {{.NAME}} placeholders for the next ones (implies -template)")
+	flag.StringVar(&from_curl, "from-curl", "", "Send the request of this curl command line (e.g. 'curl -X POST -H \"Content-Type: application/json\" -d @body.json https://...'); options also given to hammer take precedence")
+	flag.StringVar(&har_file, "har", "", "Like -flow, send in order the requests, with their headers and bodies, of this HTTP Archive (HAR) exported by a browser")
+	flag.StringVar(&har_base, "har-base", "", "With -har, send the requests to the scheme and host of this URL, leaving out those to other hosts than the first one of the archive")
+	flag.StringVar(&postman_file, "postman", "", "Like -scenario, send a weighted mix of the requests of this Postman collection (v2.1), all of them or those of -postman-requests")
+	flag.StringVar(&postman_env, "postman-env", "", "With -postman, Postman environment file whose variables override those of the collection")
+	flag.StringVar(&postman_requests, "postman-requests", "", "With -postman, comma-separated `NAME=WEIGHT` (or NAME, of weight 1) of the requests sent, by name or FOLDER/NAME path")
+	flag.StringVar(&openapi_file, "openapi", "", "Like -scenario, send a weighted mix of the operations of this OpenAPI 3 specification (JSON), all of them or those of -openapi-ops, with random parameters and bodies valid for their schemas")
+	flag.StringVar(&openapi_ops, "openapi-ops", "", "With -openapi, comma-separated `NAME=WEIGHT` (or NAME, of weight 1) of the operations sent, by operationId or tag")
+	flag.StringVar(&openapi_base, "openapi-base", "", "With -openapi, server URL of the requests instead of the first of the specification")
+	flag.BoolVar(&openapi_validate, "openapi-validate", true, "With -openapi, count the responses of undeclared status or not matching their schema as schema mismatch errors")
+	flag.StringVar(&replay_file, "replay", "", "Replay the requests of this access log (Common or Combined Log Format, or METHOD /path lines) to the scheme and host of -url, in order, once or over and over with -requests or -duration")
+	flag.Float64Var(&replay_speed, "replay-speed", 0, "With -replay, send the requests at their time in the log: 1 for the original timing, 10 ten times faster; 0 sends them as fast as possible")
+	flag.StringVar(&url_file, "url-file", "", "Instead of -url, file of http or https URLs, one per line, that each connection requests in turn")
 	flag.StringVar(&user, "user", "", "HTTP authentication user name")
+	flag.StringVar(&bearer, "bearer", "", "Send this token in an Authorization: Bearer header")
+	flag.StringVar(&bearer_file, "bearer-file", "", "Send the token read from this file in an Authorization: Bearer header")
+	flag.StringVar(&aws_sigv4, "aws-sigv4", "", "Sign requests with AWS Signature Version 4 for this `region/service` (e.g. us-east-1/execute-api), with credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN")
+	flag.DurationVar(&sign_timeout, "sign-timeout", 5*time.Second, "With -sign-cmd, time the command has to answer for each request, after which it is restarted and the request counted as failed")
+	flag.StringVar(&sign_cmd, "sign-cmd", "", "Shell command signing requests: it reads a JSON line per request ({\"method\", \"url\", \"headers\", \"body\"}) and answers with a JSON line of headers to set ({\"headers\": {\"X-Signature\": \"...\"}})")
+	flag.StringVar(&jwt_key, "jwt-key", "", "Send a JWT in an Authorization: Bearer header, signed with this key file: PEM RSA (RS256), EC P-256 (ES256) or Ed25519 (EdDSA) private key, or else HMAC secret (HS256)")
+	flag.StringVar(&jwt_claims, "jwt-claims", "", "JSON claims template of -jwt-key tokens, with {{iat}}, {{nbf}}, {{exp}}, {{jti}} and {{user}} placeholders, and {{.NAME}} ones of the -data columns (default iat, exp and jti)")
+	flag.DurationVar(&jwt_ttl, "jwt-ttl", time.Hour, "Lifetime of -jwt-key tokens, for {{exp}}")
+	flag.BoolVar(&jwt_per_user, "jwt-per-user", false, "Generate one -jwt-key token per connection rather than per request")
+	flag.StringVar(&oauth2.token_url, "oauth2-token-url", "", "Get a bearer token from this OAuth2 token endpoint with the client credentials grant, refreshed before it expires")
+	flag.StringVar(&oauth2.id, "oauth2-client-id", "", "OAuth2 client identifier")
+	flag.StringVar(&oauth2.secret, "oauth2-client-secret", "", "OAuth2 client secret")
+	flag.StringVar(&oauth2.scopes, "oauth2-scopes", "", "Space or comma-separated OAuth2 scopes requested")
+	flag.DurationVar(&bearer_reload, "bearer-reload", 0, "With -bearer-file, read the file again at this interval (e.g. 30s) to pick up a rotated token")
+	flag.Func("start-at", "Start sending requests at this time (RFC 3339, used to synchronize agents)", func(s string) (err error) {
+		start_at, err = time.Parse(time.RFC3339Nano, s)
+		return
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %[1]s [options]\n       %[1]s compare [options] old.json new.json\n       %[1]s coordinator -agents HOST:PORT,... [options] -- [options]\n       %[1]s agent [-listen ADDR]\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	if from_curl != "" {
+		opts, err := parse_curl(from_curl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		given := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+		for _, o := range opts {
+			if given[o[0]] && o[0] != "header" && o[0] != "form" {
+				continue
+			}
+			if err := flag.Set(o[0], o[1]); err != nil {
+				log.Fatalf("-from-curl: -%s %s: %v", o[0], o[1], err)
+			}
+		}
+	}
+	requests_set, url_set := false, false
+	flag.Visit(func(f *flag.Flag) {
+		requests_set = requests_set || f.Name == "requests"
+		url_set = url_set || f.Name == "url"
+	})
+	limited := duration <= 0 || requests_set // the number of requests is limited
+
+	if output != "text" && output != "json" {
+		log.Fatalf("Unknown output format %q", output)
+	}
+	if latency_sigfigs < 1 || latency_sigfigs > 5 {
+		log.Fatal("-precision must be between 1 and 5")
+	}
+	if churn {
+		ka, phases = false, true
+	}
+	if model != "" && model != "closed" && model != "open" {
+		log.Fatalf("Unknown load model %q", model)
+	}
+	if arrival != "uniform" && arrival != "poisson" {
+		log.Fatalf("Unknown arrival process %q", arrival)
+	}
+	if sse && (duration <= 0 || rate > 0 || conn_rate > 0) {
+		log.Fatal("-sse requires -duration and no -rate")
+	}
+	if pipeline > 0 && (rate > 0 || conn_rate > 0 || http2 || sse || proxy != "") {
+		log.Fatal("-pipeline cannot be used with -rate, -rate-per-conn, -http2, -sse or -proxy")
+	}
+	if pipeline > 0 && (aws_sigv4 != "" || sign_cmd != "" || cookies || jwt_per_user) {
+		log.Fatal("-pipeline cannot be used with -aws-sigv4, -sign-cmd, -cookies or -jwt-per-user")
+	}
+	if sticky_id.name != "" && !ka {
+		log.Fatal("-sticky requires keep-alive connections")
+	}
+	auths := 0
+	for _, set := range []bool{user != "", bearer != "", bearer_file != "", oauth2.token_url != "", aws_sigv4 != "", jwt_key != ""} {
+		if set {
+			auths++
+		}
+	}
+	if auths > 1 {
+		log.Fatal("-user, -bearer, -bearer-file, -oauth2-token-url, -aws-sigv4 and -jwt-key are mutually exclusive")
+	}
+	if oauth2.token_url != "" && oauth2.id == "" {
+		log.Fatal("-oauth2-token-url requires -oauth2-client-id")
+	}
+	if bearer_reload > 0 && bearer_file == "" {
+		log.Fatal("-bearer-reload requires -bearer-file")
+	}
+	if bearer_file != "" {
+		var err error
+		if bearer, err = read_token(bearer_file); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if body_file != "" && body != "" {
+		log.Fatal("-body and -body-file are mutually exclusive")
+	} else if body == "-" {
+		body_file = body
+	} else if literal, ok := strings.CutPrefix(body, "@@"); ok {
+		body = "@" + literal
+	} else if strings.HasPrefix(body, "@") {
+		body_file = body[1:]
+	}
+	if body_file != "" {
+		var b []byte
+		var err error
+		if body_file == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(body_file)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		body = string(b)
+	}
+	var scen *scenario
+	scenarios := 0
+	for _, set := range []bool{scenario_file != "", flow_file != "", har_file != "", postman_file != "", openapi_file != ""} {
+		if set {
+			scenarios++
+		}
+	}
+	if scenarios > 1 {
+		log.Fatal("-scenario, -flow, -har, -postman and -openapi are mutually exclusive")
+	}
+	if scenarios > 0 {
+		if url_set || url_file != "" || body != "" || body_file != "" || len(form_fields) > 0 {
+			log.Fatal("-scenario, -flow, -har, -postman and -openapi cannot be used with -url, -url-file, -body, -body-file or -form")
+		}
+		if pipeline > 0 || sse || grpc_method != "" || graphql_file != "" {
+			log.Fatal("-scenario, -flow, -har, -postman and -openapi cannot be used with -pipeline, -sse, -grpc or -graphql")
+		}
+		var err error
+		if har_file != "" {
+			scen, err = read_har(har_file, har_base)
+		} else if postman_file != "" {
+			var dynamic bool
+			scen, dynamic, err = read_postman(postman_file, postman_env, postman_requests)
+			template = template || dynamic
+		} else if openapi_file != "" {
+			if template || data_file != "" {
+				log.Fatal("-openapi cannot be used with -template or -data")
+			}
+			scen, err = read_openapi(openapi_file, openapi_base, openapi_ops, openapi_validate)
+		} else {
+			scen, err = read_scenario(scenario_file + flow_file)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if flow_file != "" {
+			scen.flow, template = true, true
+		} else if slices.ContainsFunc(scen.endpoints, func(e endpoint) bool { return len(e.extract) > 0 }) {
+			log.Fatal("extract keys require -flow")
+		}
+		url = scen.endpoints[0].url.String()
+	} else if har_base != "" {
+		log.Fatal("-har-base requires -har")
+	}
+	if (postman_env != "" || postman_requests != "") && postman_file == "" {
+		log.Fatal("-postman-env and -postman-requests require -postman")
+	}
+	if (openapi_ops != "" || openapi_base != "") && openapi_file == "" {
+		log.Fatal("-openapi-ops and -openapi-base require -openapi")
+	}
+	if (len(fuzz_q) > 0 || len(fuzz_p) > 0) && (pipeline > 0 || sse || grpc_method != "") {
+		log.Fatal("-fuzz-query and -fuzz-path cannot be used with -pipeline, -sse or -grpc")
+	}
+	var urls []*neturl.URL
+	if url_file != "" {
+		if url_set {
+			log.Fatal("-url and -url-file are mutually exclusive")
+		}
+		if pipeline > 0 || sse || grpc_method != "" {
+			log.Fatal("-url-file cannot be used with -pipeline, -sse or -grpc")
+		}
+		var err error
+		if urls, err = read_urls(url_file); err != nil {
+			log.Fatal(err)
+		}
+		url = urls[0].String()
+	}
+	var rp *replay
+	if replay_file != "" {
+		if url_file != "" || scen != nil || template || data_file != "" || body != "" || len(form_fields) > 0 {
+			log.Fatal("-replay cannot be used with -url-file, -scenario, -flow, -template, -data, -body or -form")
+		}
+		if pipeline > 0 || sse || grpc_method != "" || graphql_file != "" {
+			log.Fatal("-replay cannot be used with -pipeline, -sse, -grpc or -graphql")
+		}
+		base, err := neturl.Parse(url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if rp, err = read_replay(replay_file, base); err != nil {
+			log.Fatal(err)
+		}
+		rp.speed, rp.loop = replay_speed, requests_set || duration > 0
+	} else if replay_speed != 0 {
+		log.Fatal("-replay-speed requires -replay")
+	}
+	var data *feeder
+	if data_file != "" {
+		if data_end != "recycle" && data_end != "stop" {
+			log.Fatalf("Unknown -data-end %q", data_end)
+		}
+		if pipeline > 0 {
+			log.Fatal("-data cannot be used with -pipeline")
+		}
+		var err error
+		if data, err = read_data(data_file); err != nil {
+			log.Fatal(err)
+		}
+		data.recycle, data.per_user = data_end == "recycle", data_per_user
+		template = true
+	} else if data_per_user {
+		log.Fatal("-data-per-user requires -data")
+	}
+	if template {
+		if url_file != "" || len(form_fields) > 0 || grpc_method != "" || graphql_file != "" {
+			log.Fatal("-template cannot be used with -url-file, -form, -grpc or -graphql")
+		}
+		checked := []string{url, body}
+		for _, hf := range hdr {
+			checked = append(checked, hf.value)
+		}
+		var vars map[string]string
+		if scen != nil {
+			vars = scen.new_vars()
+			for _, e := range scen.endpoints {
+				checked = append(checked, e.raw, e.body)
+				for _, hf := range e.hdr {
+					checked = append(checked, hf.value)
+				}
+			}
+		}
+		for _, s := range checked {
+			if err := check_template(s, data, vars); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if form_per_request && len(form_fields) == 0 {
+		log.Fatal("-form-per-request requires -form")
+	}
+	if (chunk_size > 0 || chunk_delay > 0) && !chunked {
+		log.Fatal("-chunk-size and -chunk-delay require -chunked")
+	}
+	if chunked && chunk_size <= 0 {
+		chunk_size = 4096
+	}
+	if jitter > think {
+		log.Fatal("-think-jitter cannot exceed -think")
+	}
+	if corrected && rate <= 0 && conn_rate <= 0 {
+		log.Fatal("-co-correct requires -rate or -rate-per-conn")
+	}
+
+	// With more outstanding requests than connections, there is one worker
+	// per request, waiting for a connection to be available
+	conns := conc
+	if max_inflight > conc {
+		conc = max_inflight
+	}
+
+	if socket, u, ok := split_unix_url(url); ok {
+		unix_socket, url = socket, u
+	}
+	if graphql_file != "" {
+		var err error
+		if body, err = graphql_body(graphql_file, variables_file); err != nil {
+			log.Fatal(err)
+		}
+		method = "POST"
+		hdr = append(hdr, hfield{"Content-Type", "application/json"})
+	}
+	if comp != nil {
+		hdr = append(hdr, hfield{"Accept-Encoding", strings.Join(comp, ", ")})
+	}
+	if len(form_fields) > 0 {
+		var content_type string
+		body, content_type = form_fields.build()
+		if method == "GET" {
+			method = "POST"
+		}
+		hdr = append(hdr, hfield{"Content-Type", content_type})
+	}
+	if grpc_method != "" {
+		var ps *proto_set
+		if proto_set_file != "" {
+			var err error
+			if ps, err = read_proto_set(proto_set_file); err != nil {
+				log.Fatal(err)
+			}
+		}
+		var err error
+		if body, err = grpc_request(ps, grpc_method, body); err != nil {
+			log.Fatal(err)
+		}
+		method, http2 = "POST", true
+		url = strings.TrimSuffix(url, "/") + "/" + grpc_method
+		hdr = append(hdr, hfield{"Content-Type", "application/grpc"}, hfield{"TE", "trailers"})
+	}
 
 	// Use cpus kernel threads
 	runtime.GOMAXPROCS(cpus)
 
 	// Create HTTP client according to configuration
 	var transport = &http.Transport{
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true, CipherSuites: []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}},
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: insecure},
 		DisableKeepAlives:   !ka,
-		DisableCompression:  !comp,
-		MaxIdleConnsPerHost: conc,
+		DisableCompression:  true, // see -compress
+		MaxIdleConnsPerHost: conns,
+	}
+	if max_inflight > conns {
+		transport.MaxConnsPerHost = conns
+	}
+	if tls_max != 0 && tls_min > tls_max {
+		log.Fatal("-tls-min cannot exceed -tls-max")
+	}
+	if ciphers != "" {
+		ids, err := parse_ciphers(ciphers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transport.TLSClientConfig.CipherSuites = ids
+	}
+	transport.TLSClientConfig.ServerName = sni
+	if alpn != "" {
+		protos := strings.Split(alpn, ",")
+		if slices.Contains(protos, "h2") && !http2 {
+			log.Fatal("-alpn h2 requires -http2")
+		}
+		transport.TLSClientConfig.NextProtos = protos
+	}
+	if tls_sessions {
+		transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if len(pinned) > 0 {
+		transport.TLSClientConfig.VerifyConnection = pinned.verify
+	}
+	best := uint16(tls_max)
+	if best == 0 {
+		best = tls.VersionTLS13
+	}
+	switch downgrades {
+	case "", "warn":
+	case "reject":
+		verify := transport.TLSClientConfig.VerifyConnection
+		transport.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if reason := downgrade(cs, best); reason != "" {
+				return downgrade_error(reason)
+			}
+			if verify != nil {
+				return verify(cs)
+			}
+			return nil
+		}
+	default:
+		log.Fatal("-tls-downgrade must be warn or reject")
+	}
+	if keylog != "" {
+		f, err := os.OpenFile(keylog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transport.TLSClientConfig.KeyLogWriter = f
+	}
+	transport.TLSClientConfig.MinVersion = uint16(tls_min)
+	transport.TLSClientConfig.MaxVersion = uint16(tls_max)
+	if cacert != "" {
+		pool, err := load_ca(cacert)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if client_cert != "" {
+		get, n, err := client_certs(client_cert, client_key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transport.TLSClientConfig.GetClientCertificate = get
+		if n > 1 {
+			log.Printf("Presenting %d client certificates in turn", n)
+		}
+	} else if client_key != "" {
+		log.Fatal("-key requires -cert")
+	}
+	if expect_continue > 0 {
+		if body == "" {
+			log.Fatal("-expect-continue requires a request body")
+		}
+		transport.ExpectContinueTimeout = expect_continue
+	}
+	if unix_socket != "" {
+		transport.DialContext = unix_dialer(unix_socket)
+	}
+	if proxy != "" {
+		u, err := parse_proxy(proxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if proxy_user != "" || proxy_pass != "" {
+			auth, err := proxy_credentials(u, proxy_user, proxy_pass)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if auth != "" {
+				transport.ProxyConnectHeader = http.Header{"Proxy-Authorization": {auth}}
+				if strings.HasPrefix(url, "http:") {
+					hdr = append(hdr, hfield{"Proxy-Authorization", auth})
+				}
+			}
+		}
+		transport.Proxy = http.ProxyURL(u)
+	} else if proxy_user != "" || proxy_pass != "" {
+		log.Fatal("-proxy-user and -proxy-pass require -proxy")
+	}
+	if http2 {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
 	}
 	var client = &http.Client{
-		Transport: transport,
+		Transport:     transport,
+		CheckRedirect: check_redirect(int(follow)),
+		Timeout:       timeout,
 	}
 
 	// Profiling
@@ -147,20 +1262,446 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	// Load profile
+	var stages []stage
+	var sr *stage_recorder
+	var g *gate
+	var paced bool
+	profiles := 0
+	for _, set := range []bool{stages_spec != "", spike_spec != "", plan_file != "", search, capacity} {
+		if set {
+			profiles++
+		}
+	}
+	if profiles > 1 {
+		log.Fatal("-stages, -spike, -plan, -search and -capacity are mutually exclusive")
+	}
+	if capacity && limits.max_p99 <= 0 {
+		log.Fatal("-capacity requires -max-p99")
+	}
+	if search && rate > 0 {
+		log.Fatal("-search works with closed loop (no -rate)")
+	}
+	if stages_spec != "" {
+		var err error
+		if stages, err = parse_stages(stages_spec); err != nil {
+			log.Fatal(err)
+		}
+	} else if plan_file != "" {
+		var err error
+		if stages, err = read_plan(plan_file); err != nil {
+			log.Fatal(err)
+		}
+	} else if spike_spec != "" {
+		var err error
+		if stages, err = spike_stages(spike_spec, rate, conc, duration); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if search {
+		sr = &stage_recorder{}
+		add_live_hook(sr.sample)
+		g = new_gate(1)
+	}
+	if capacity {
+		sr = &stage_recorder{}
+		add_live_hook(sr.sample)
+		paced = true
+		if rate <= 0 {
+			rate = 10
+		}
+	}
+	if stages != nil {
+		sr = &stage_recorder{}
+		add_live_hook(sr.sample)
+		stage_conc := 0
+		gaps := false
+		for i := range stages {
+			s := &stages[i]
+			paced = paced || s.rate > 0
+			stage_conc = max(stage_conc, s.conc)
+			if s.cooldown == 0 {
+				s.cooldown = cooldown
+			}
+			gaps = gaps || s.cooldown > 0
+		}
+		// Start enough workers for the largest stage, only -concurrency
+		// of them being active until a stage says otherwise (gaps also
+		// rely on the gate to pause workers)
+		if stage_conc > 0 || gaps {
+			g = new_gate(conc)
+			conc = max(conc, stage_conc)
+		}
+	}
+
+	// Runtime adjustments: start enough workers to raise the concurrency up
+	// to -max-concurrency, only -concurrency of them being active
+	adjustable := control_addr != "" || adjust_step > 0
+	if control_addr != "" && control_token == "" {
+		log.Fatal("-control requires a -control-token (or $HAMMER_AGENT_TOKEN)")
+	}
+	if adjustable {
+		if g == nil {
+			g = new_gate(conc)
+		}
+		conc = max(conc, max_conc)
+	}
+
+	// Open loop: requests are scheduled at a fixed rate shared by all workers
+	var pc *pacer
+	if target_p99 > 0 && (stages != nil || search || capacity) {
+		log.Fatal("-target-p99 is incompatible with -stages, -spike, -search and -capacity")
+	}
+	if rate > 0 || paced || target_p99 > 0 {
+		pc = new_pacer(rate, arrival == "poisson")
+	}
+	if conn_rate > 0 && (pc != nil || search || capacity) {
+		log.Fatal("-rate-per-conn is incompatible with -rate, -target-p99, -search and stages setting a rate")
+	}
+	switch open := pc != nil || conn_rate > 0; {
+	case model == "":
+		model = "closed"
+		if open {
+			model = "open"
+		}
+	case model == "open" && !open:
+		log.Fatal("-model open requires -rate, -rate-per-conn, -target-p99 or stages setting a rate")
+	case model == "closed" && open:
+		log.Fatal("-rate, -rate-per-conn, -target-p99 and stages setting a rate require -model open")
+	}
+	if model == "open" && think > 0 {
+		log.Fatal("-think requires -model closed")
+	}
+
+	cfg := report_config{
+		URL:         url,
+		Method:      method,
+		Model:       model,
+		Concurrency: conns,
+		MaxInflight: max_inflight,
+		Rate:        rate,
+		RatePerConn: conn_rate,
+		KeepAlive:   ka,
+		HTTP2:       http2,
+		Compress:    comp != nil,
+		Encodings:   comp,
+		Corrected:   corrected,
+		Think:       think.Seconds() * 1000,
+		Jitter:      jitter.Seconds() * 1000,
+		MaxBytes:    int64(max_bytes),
+		Handshake:   handshake,
+	}
+	if pc != nil || conn_rate > 0 {
+		cfg.Arrival = arrival
+	}
+	if duration > 0 {
+		cfg.Duration = duration.Seconds()
+	}
+	if limited {
+		cfg.Requests = reqs
+	}
+
+	// Live statistics
+	if timeseries_file != "" {
+		f, err := os.Create(timeseries_file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		add_live_hook(new_timeseries(f, pc, g, conc).sample)
+	}
+	if ui {
+		add_live_hook(new_dashboard(os.Stderr, url).sample)
+	}
+	if progress_every > 0 {
+		add_live_hook(new_progress(os.Stderr, progress_every).sample)
+	}
+	if metrics_addr != "" {
+		m := new_metrics()
+		add_live_hook(m.sample)
+		m.serve(metrics_addr)
+	}
+	if sink_spec != "" {
+		sk, err := new_sink(sink_spec, sink_prefix, url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		add_live_hook(sk.sample)
+	}
+	var exporter *otlp
+	if otlp_endpoint != "" {
+		exporter = new_otlp(otlp_endpoint, otlp_every, test_name, url)
+		add_live_hook(exporter.sample)
+	}
+	if abort_error_rate > 0 {
+		add_live_hook((&breaker{limit: abort_error_rate}).sample)
+	}
+	var thr *throttle
+	if target_p99 > 0 {
+		initial := rate
+		if initial <= 0 {
+			initial = 10
+		}
+		thr = new_throttle(pc, target_p99, initial)
+		add_live_hook(thr.sample)
+	}
+	if soak_every > 0 {
+		if soak_dir != "" {
+			make_soak_dir(soak_dir)
+		}
+		add_live_hook(new_soak(cfg, soak_every, soak_dir, os.Stderr).sample)
+	}
+	var win *window
+	if window_size > 0 {
+		win = new_window(window_size)
+		add_live_hook(win.sample)
+	}
+
 	// Create goroutines
+	j := &job{
+		client: client,
+		method: method,
+		url:    url,
+		body:   body,
+		hdr:    hdr,
+		user:   user,
+		pass:   pass,
+		pacer:  pc,
+
+		corrected: corrected,
+		phases:    phases,
+		gate:      g,
+		think:     think,
+		jitter:    jitter,
+		conn_rate: conn_rate,
+		poisson:   arrival == "poisson",
+		max_bytes: int64(max_bytes),
+
+		chunk_size:  int(chunk_size),
+		chunk_delay: chunk_delay,
+		urls:        urls,
+		scenario:    scen,
+		template:    template,
+		data:        data,
+		replay:      rp,
+	}
+	if adjustable && limited && sr == nil && (rp == nil || requests_set) {
+		j.budget = new_budget(reqs)
+	}
+	if form_per_request {
+		j.form = form_fields
+	}
+	if len(fuzz_q) > 0 || len(fuzz_p) > 0 {
+		j.fuzz = &url_fuzzer{fuzz_q, fuzz_p}
+	}
+	j.cookies = cookies
+	if aws_sigv4 != "" {
+		signer, err := new_sigv4_signer(aws_sigv4)
+		if err != nil {
+			log.Fatal(err)
+		}
+		j.sigv4 = signer
+	}
+	if jwt_key != "" {
+		if jwt_per_user && data != nil && !data.per_user {
+			log.Fatal("-jwt-per-user with -data requires -data-per-user")
+		}
+		signer, err := new_jwt_signer(jwt_key, jwt_claims, jwt_ttl, data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		signer.per_user = jwt_per_user
+		// Workers sign their token before the run: check the claims of
+		// each row they get beforehand, as they cannot fail the run
+		if jwt_per_user && data != nil {
+			for id := 1; id < min(conc, len(data.rows)); id++ {
+				if _, err := signer.expand_claims(id, data.rows[id]); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		j.jwt = signer
+	}
+	if sign_cmd != "" {
+		hook, err := start_sign_hook(sign_cmd, sign_timeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer hook.close()
+		j.sign_hook = hook
+	}
+	if bearer != "" || bearer_file != "" {
+		j.bearer = &bearer_token{}
+		j.bearer.set(bearer)
+		if bearer_reload > 0 {
+			go j.bearer.reload(bearer_file, bearer_reload)
+		}
+	} else if oauth2.token_url != "" {
+		oauth2.client = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: transport.TLSClientConfig.Clone(), Proxy: transport.Proxy},
+			Timeout:   30 * time.Second,
+		}
+		oauth2.scopes = strings.Join(strings.FieldsFunc(oauth2.scopes, func(r rune) bool { return r == ' ' || r == ',' }), " ")
+		token, lifetime, err := oauth2.fetch()
+		if err != nil {
+			log.Fatal(err)
+		}
+		j.bearer = &bearer_token{}
+		j.bearer.set(token)
+		go oauth2.refresh(j.bearer, lifetime)
+	}
+	j.encodings = comp
+	j.expect_continue = expect_continue > 0
+	if sticky_id.name != "" {
+		j.sticky = &sticky_id
+		j.own_conn = true
+	}
+	if backend_header != "" {
+		j.affinity = new_affinity_tracker(backend_header, conc)
+	}
+	if http2 {
+		j.conns = new_conn_tracker()
+	}
+	if handshake {
+		rt, err := new_handshake_target(url, transport.TLSClientConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		j.raw = rt
+		rt.timeout = timeout
+	} else if rt, err := new_dns_target(url); err != nil {
+		log.Fatal(err)
+	} else if rt != nil {
+		j.raw = rt
+		// Do not wait forever for lost datagrams
+		rt.timeout = timeout
+		if rt.timeout <= 0 {
+			rt.timeout = 5 * time.Second
+		}
+	} else if rt, err := new_raw_target(url, transport.TLSClientConfig); err != nil {
+		log.Fatal(err)
+	} else if rt != nil {
+		j.raw = rt
+		if payload_file != "" {
+			if rt.payload, err = os.ReadFile(payload_file); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if expect_delim != "" {
+			delim, err := strconv.Unquote(`"` + expect_delim + `"`)
+			if err != nil {
+				log.Fatalf("Invalid -expect-delim %q", expect_delim)
+			}
+			rt.delim = []byte(delim)
+		}
+		rt.expect_bytes, rt.timeout = expect_bytes, timeout
+	}
+	https := slices.ContainsFunc(urls, func(u *neturl.URL) bool { return u.Scheme == "https" })
+	if scen != nil {
+		https = slices.ContainsFunc(scen.endpoints, func(e endpoint) bool { return e.url.Scheme == "https" })
+	}
+	if https || strings.HasPrefix(url, "https://") || (j.raw != nil && j.raw.tls != nil) {
+		j.tls = new_tls_tracker()
+		if downgrades == "warn" {
+			j.tls.best = best
+		}
+	}
+	j.grpc = grpc_method != ""
+	j.graphql = graphql_file != ""
+	if max_inflight > 0 && max_inflight < conns {
+		j.own_conn = ka
+		j.inflight = make(chan bool, max_inflight)
+	}
+	if save_failures > 0 {
+		f, err := os.Create(failures_file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		j.failures = new_failure_log(f, save_failures)
+	}
+	workers := make([]*stats, conc)
+	if scen != nil {
+		scen.init()
+	}
+	var streams []*sse_stats
+	var pipelines []*pipeline_stats
 	remaining := reqs
 	for i := 0; i < conc; i++ {
 		n := remaining / (conc - i)
-		go send_requests(client, n, method, url, body, hdr, user, pass)
+		if !limited || sr != nil || (rp != nil && !requests_set) || j.budget != nil {
+			n = -1
+		}
+		workers[i] = new_stats()
+		delay := ramp * time.Duration(i) / time.Duration(conc)
+		if sse {
+			streams = append(streams, new_sse_stats())
+			go consume_events(j, i, delay, workers[i], streams[i])
+		} else if j.raw != nil {
+			go send_payloads(j, i, n, delay, workers[i])
+		} else if pipeline > 0 {
+			pipelines = append(pipelines, &pipeline_stats{})
+			go send_pipelined(j, i, n, delay, pipeline, workers[i], pipelines[i])
+		} else {
+			go send_requests(j, i, n, delay, workers[i])
+		}
 		remaining -= n
 	}
 
+	if adjustable {
+		ctl := new_control(j, conc)
+		if control_addr != "" {
+			ctl.serve(control_addr, control_token)
+		}
+		if adjust_step > 0 {
+			ctl.handle_signals(adjust_step)
+		}
+	}
+	handle_signals()
+
 	// Wait for worker goroutines to get ready
 	for i := 0; i < conc; i++ {
 		<-ready_ch
 	}
 
+	if !start_at.IsZero() {
+		time.Sleep(time.Until(start_at))
+	}
 	begin := time.Now()
+	if pc != nil {
+		pc.start(begin)
+	}
+	if rp != nil {
+		rp.start(begin)
+	}
+	var timed_out atomic.Bool
+	if duration > 0 {
+		time.AfterFunc(duration, func() {
+			timed_out.Store(!stopped())
+			stop_workers()
+		})
+	}
+	if live != nil {
+		live.start(begin, time.Second)
+	}
+	if stages != nil {
+		go run_stages(stages, j, sr)
+	}
+	var knee *knee_report
+	searched := make(chan bool)
+	if search {
+		go func() {
+			knee = search_knee(j, conc, search_step, search_gain, limits.max_p99, sr)
+			close(searched)
+		}()
+	}
+	var capa *capacity_report
+	if capacity {
+		go func() {
+			capa = search_capacity(j, rate, capacity_max, search_step, capacity_precision, limits.max_p99, sr)
+			close(searched)
+		}()
+	}
 
 	// Start sending requests
 	for i := 0; i < conc; i++ {
@@ -172,9 +1713,118 @@ func main() {
 	}
 
 	end := time.Now()
-	elapsed := float32(end.Sub(begin))
-	throughput := float32(reqs) * 1000000000 / elapsed
-	fmt.Printf("%d requests sent in %.2f seconds - average throughput %.2f tps\n", reqs, elapsed/1000000000, throughput)
+	if live != nil {
+		live.stop()
+	}
+	if exporter != nil {
+		exporter.flush()
+	}
+
+	total := new_stats()
+	for _, st := range workers {
+		total.merge(st)
+	}
+
+	// Cool-down gaps are excluded from the duration of the run
+	if sr != nil {
+		end = end.Add(-sr.idle)
+	}
+	rep := new_report(cfg, total, begin, end)
+	rep.Interrupted = interrupted.Load()
+	if reason, ok := abort_reason.Load().(string); ok {
+		rep.Aborted = reason
+	}
+	switch {
+	case rep.Interrupted || rep.Aborted != "":
+	case max_bytes > 0 && j.received.Load() >= int64(max_bytes):
+		rep.EndedBy = "bytes"
+	case sr != nil:
+	case timed_out.Load():
+		rep.EndedBy = "duration"
+	case limited:
+		rep.EndedBy = "requests"
+	}
+	if histograms {
+		rep.Histograms = &report_histograms{total.latency.data(), total.ttfb.data()}
+	}
+	if j.conns != nil {
+		rep.HTTP2 = j.conns.report()
+	}
+	if sse {
+		ss := new_sse_stats()
+		for _, s := range streams {
+			ss.merge(s)
+		}
+		rep.SSE = new_sse_report(ss, rep.Duration)
+	}
+	if j.tls != nil {
+		rep.TLS = j.tls.report()
+	}
+	if comp != nil {
+		rep.Compression = new_compression_report(total.encodings)
+	}
+	if j.affinity != nil {
+		rep.Affinity = j.affinity.report()
+	}
+	if pipeline > 0 {
+		ps := &pipeline_stats{}
+		for _, p := range pipelines {
+			ps.merge(p)
+		}
+		rep.Pipeline = new_pipeline_report(pipeline, ps)
+	}
+	if churn {
+		rep.Churn = new_churn_report(total, rep.Duration)
+	}
+	if win != nil {
+		rep.add_window(win)
+	}
+	if sr != nil {
+		rep.add_stages(sr)
+	}
+	if scen != nil {
+		rep.add_endpoints(scen)
+	}
+	if search || capacity {
+		<-searched
+		rep.Knee, rep.Capacity = knee, capa
+	}
+	if thr != nil {
+		rep.Throttle = thr.report()
+	}
+	if per_worker {
+		rep.add_workers(workers)
+	}
+	rep.Assertions = limits.check(rep)
+
+	var out io.Writer = os.Stdout
+	if output_file != "" {
+		f, err := os.Create(output_file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if output == "json" {
+		if err := write_json(out, rep); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		write_text(out, rep, total)
+	}
+
+	if hgrm_file != "" {
+		write_file(hgrm_file, func(w io.Writer) error { return total.latency.write_hgrm(w, 1000) })
+	}
+	if junit_file != "" {
+		write_file(junit_file, func(w io.Writer) error { return write_junit(w, rep) })
+	}
+
+	if !passed(rep.Assertions) {
+		pprof.StopCPUProfile()
+		os.Exit(2)
+	}
 
 	// Profiling
 	//if memprof != "" {
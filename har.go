@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"strings"
+)
+
+// har is the part of an HTTP Archive (HAR 1.2) describing the requests.
+type har struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// Request headers of archives not replayed: set by the client itself, or
+// describing the browser connection
+var har_skipped = map[string]bool{
+	"host": true, "content-length": true, "connection": true, "keep-alive": true,
+	"transfer-encoding": true, "accept-encoding": true, "upgrade": true, "te": true,
+}
+
+// read_har returns the -flow scenario replaying in order the requests of
+// an HTTP Archive, as exported by browsers. If base is set, the requests
+// are sent to its scheme and host instead, and those of the archive to
+// other hosts than the first one (third-party resources) are left out.
+func read_har(file, base string) (*scenario, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var h har
+	if err := json.Unmarshal(b, &h); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	var to *neturl.URL
+	if base != "" {
+		if to, err = neturl.Parse(base); err != nil {
+			return nil, err
+		}
+		if (to.Scheme != "http" && to.Scheme != "https") || to.Host == "" {
+			return nil, fmt.Errorf("%q is not an http or https URL", base)
+		}
+	}
+	s := &scenario{flow: true}
+	host := ""
+	skipped := 0
+	for _, entry := range h.Log.Entries {
+		r := entry.Request
+		u, err := neturl.Parse(r.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			skipped++
+			continue
+		}
+		if host == "" {
+			host = u.Host
+		}
+		if to != nil {
+			if u.Host != host {
+				skipped++
+				continue
+			}
+			u.Scheme, u.Host = to.Scheme, to.Host
+		}
+		e := endpoint{method: r.Method, url: u, raw: u.String(), weight: 1}
+		for _, hf := range r.Headers {
+			if !har_skipped[strings.ToLower(hf.Name)] && !strings.HasPrefix(hf.Name, ":") {
+				e.hdr = append(e.hdr, hfield{hf.Name, hf.Value})
+			}
+		}
+		if r.PostData != nil {
+			e.body = r.PostData.Text
+		}
+		s.endpoints = append(s.endpoints, e)
+	}
+	if len(s.endpoints) == 0 {
+		return nil, fmt.Errorf("%s: no request found", file)
+	}
+	if skipped > 0 {
+		log.Printf("%s: left out %d requests to other hosts or schemes", file, skipped)
+	}
+	s.prepare()
+	return s, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// histogram is an HDR (High Dynamic Range) histogram: values are recorded
+// with a fixed number of significant digits over a fixed range, so memory
+// usage is constant whatever the number of recorded values.
+// See http://hdrhistogram.org/ for the original design.
+type histogram struct {
+	lowest                int64
+	highest               int64
+	sigfigs               int
+	unit_magnitude        uint
+	sub_bucket_half_magn  uint
+	sub_bucket_count      int64
+	sub_bucket_half_count int64
+	sub_bucket_mask       int64
+	bucket_count          int
+	counts                []int64
+	total                 int64
+	min                   int64
+	max                   int64
+}
+
+// new_histogram creates a histogram tracking values between lowest and
+// highest (inclusive) with sigfigs significant decimal digits (1 to 5).
+func new_histogram(lowest, highest int64, sigfigs int) *histogram {
+	if lowest < 1 {
+		lowest = 1
+	}
+	if sigfigs < 1 {
+		sigfigs = 1
+	} else if sigfigs > 5 {
+		sigfigs = 5
+	}
+	h := &histogram{lowest: lowest, highest: highest, sigfigs: sigfigs}
+
+	largest_single_unit := 2 * int64(math.Pow10(sigfigs))
+	h.unit_magnitude = uint(math.Floor(math.Log2(float64(lowest))))
+	h.sub_bucket_half_magn = uint(math.Ceil(math.Log2(float64(largest_single_unit)))) - 1
+	h.sub_bucket_count = int64(1) << (h.sub_bucket_half_magn + 1)
+	h.sub_bucket_half_count = h.sub_bucket_count / 2
+	h.sub_bucket_mask = (h.sub_bucket_count - 1) << h.unit_magnitude
+
+	// Number of power-of-two buckets needed to cover the whole range
+	smallest_untrackable := h.sub_bucket_count << h.unit_magnitude
+	buckets := 1
+	for smallest_untrackable <= highest {
+		if smallest_untrackable > math.MaxInt64/2 {
+			buckets++
+			break
+		}
+		smallest_untrackable <<= 1
+		buckets++
+	}
+	h.bucket_count = buckets
+	h.counts = make([]int64, int64(buckets+1)*h.sub_bucket_half_count)
+	h.min = math.MaxInt64
+	return h
+}
+
+func (h *histogram) bucket_index(v int64) int {
+	pow2ceiling := 64 - bits.LeadingZeros64(uint64(v|h.sub_bucket_mask))
+	return pow2ceiling - int(h.unit_magnitude) - int(h.sub_bucket_half_magn+1)
+}
+
+func (h *histogram) sub_bucket_index(v int64, idx int) int64 {
+	return v >> (uint(idx) + h.unit_magnitude)
+}
+
+func (h *histogram) counts_index(idx int, sub int64) int {
+	base := int64(idx+1) << h.sub_bucket_half_magn
+	return int(base + sub - h.sub_bucket_half_count)
+}
+
+// value_from_index returns the lowest value stored in the counts slot i.
+func (h *histogram) value_from_index(i int) int64 {
+	idx := (i >> h.sub_bucket_half_magn) - 1
+	sub := int64(i)&(h.sub_bucket_half_count-1) + h.sub_bucket_half_count
+	if idx < 0 {
+		sub -= h.sub_bucket_half_count
+		idx = 0
+	}
+	return sub << (uint(idx) + h.unit_magnitude)
+}
+
+func (h *histogram) equivalent_range(v int64) int64 {
+	idx := h.bucket_index(v)
+	if h.sub_bucket_index(v, idx) >= h.sub_bucket_count {
+		idx++
+	}
+	return int64(1) << (h.unit_magnitude + uint(idx))
+}
+
+func (h *histogram) lowest_equivalent(v int64) int64 {
+	idx := h.bucket_index(v)
+	return h.sub_bucket_index(v, idx) << (uint(idx) + h.unit_magnitude)
+}
+
+func (h *histogram) highest_equivalent(v int64) int64 {
+	return h.lowest_equivalent(v) + h.equivalent_range(v) - 1
+}
+
+func (h *histogram) median_equivalent(v int64) int64 {
+	return h.lowest_equivalent(v) + h.equivalent_range(v)>>1
+}
+
+// record adds one occurrence of value v. Values outside of the trackable
+// range are clamped to it.
+func (h *histogram) record(v int64) {
+	h.record_n(v, 1)
+}
+
+// record_n adds n occurrences of value v.
+func (h *histogram) record_n(v int64, n int64) {
+	if v < 0 {
+		v = 0
+	} else if v > h.highest {
+		v = h.highest
+	}
+	idx := h.bucket_index(v)
+	h.counts[h.counts_index(idx, h.sub_bucket_index(v, idx))] += n
+	h.total += n
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// merge adds all the values recorded in o to h. Both histograms must have
+// been created with the same parameters.
+func (h *histogram) merge(o *histogram) {
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.total += o.total
+	if o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// count returns the number of recorded values.
+func (h *histogram) count() int64 {
+	return h.total
+}
+
+// minimum returns the smallest recorded value, or 0 if the histogram is empty.
+func (h *histogram) minimum() int64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.lowest_equivalent(h.min)
+}
+
+// maximum returns the largest recorded value, or 0 if the histogram is empty.
+func (h *histogram) maximum() int64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.highest_equivalent(h.max)
+}
+
+// mean returns the arithmetic mean of the recorded values.
+func (h *histogram) mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	var sum float64
+	for i, c := range h.counts {
+		if c != 0 {
+			sum += float64(h.median_equivalent(h.value_from_index(i))) * float64(c)
+		}
+	}
+	return sum / float64(h.total)
+}
+
+// value_at_percentile returns the value below which p percent of the
+// recorded values fall.
+func (h *histogram) value_at_percentile(p float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	if p > 100 {
+		p = 100
+	}
+	target := int64(p/100*float64(h.total) + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			return h.highest_equivalent(h.value_from_index(i))
+		}
+	}
+	return h.maximum()
+}
+
+// std_dev returns the standard deviation of the recorded values.
+func (h *histogram) std_dev() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	mean := h.mean()
+	var sum float64
+	for i, c := range h.counts {
+		if c != 0 {
+			dev := float64(h.median_equivalent(h.value_from_index(i))) - mean
+			sum += dev * dev * float64(c)
+		}
+	}
+	return math.Sqrt(sum / float64(h.total))
+}
+
+// write_hgrm writes the percentile distribution of the histogram in the
+// .hgrm text format of the HdrHistogram tools. Values are divided by scale.
+func (h *histogram) write_hgrm(w io.Writer, scale float64) error {
+	const ticks_per_half_distance = 5
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	if h.total != 0 {
+		to := 0.0
+		var cum int64
+	values:
+		for i, c := range h.counts {
+			if c == 0 {
+				continue
+			}
+			cum += c
+			v := float64(h.highest_equivalent(h.value_from_index(i))) / scale
+			for 100*float64(cum)/float64(h.total) >= to {
+				fmt.Fprintf(bw, "%12.3f %2.12f %10d %14.2f\n", v, to/100, cum, 1/(1-to/100))
+				if cum == h.total {
+					fmt.Fprintf(bw, "%12.3f %2.12f %10d\n", v, 1.0, cum)
+					break values
+				}
+				ticks := ticks_per_half_distance * math.Pow(2, math.Floor(math.Log2(100/(100-to)))+1)
+				to += 100 / ticks
+			}
+		}
+	}
+	fmt.Fprintf(bw, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", h.mean()/scale, h.std_dev()/scale)
+	fmt.Fprintf(bw, "#[Max     = %12.3f, Total count    = %12d]\n", float64(h.maximum())/scale, h.total)
+	fmt.Fprintf(bw, "#[Buckets = %12d, SubBuckets     = %12d]\n", h.bucket_count, h.sub_bucket_count)
+	return bw.Flush()
+}
+
+// count_at_or_below returns the number of recorded values lower than or
+// equivalent to v.
+func (h *histogram) count_at_or_below(v int64) int64 {
+	var n int64
+	for i, c := range h.counts {
+		if h.value_from_index(i) > v {
+			break
+		}
+		n += c
+	}
+	return n
+}
+
+// histogram_data is the sparse form of a histogram exchanged between hammer
+// processes: the count of each non-empty slot, indexed by its lowest value.
+type histogram_data struct {
+	Min    int64      `json:"min"`
+	Max    int64      `json:"max"`
+	Counts [][2]int64 `json:"counts"`
+}
+
+// data returns the sparse form of the histogram.
+func (h *histogram) data() *histogram_data {
+	d := &histogram_data{Min: h.minimum(), Max: h.max}
+	for i, c := range h.counts {
+		if c != 0 {
+			d.Counts = append(d.Counts, [2]int64{h.value_from_index(i), c})
+		}
+	}
+	return d
+}
+
+// add_data adds the values of a histogram in sparse form.
+func (h *histogram) add_data(d *histogram_data) {
+	if d == nil {
+		return
+	}
+	for _, vc := range d.Counts {
+		h.record_n(vc[0], vc[1])
+	}
+	if len(d.Counts) != 0 {
+		h.min = min(h.min, d.Min)
+		h.max = max(h.max, d.Max)
+	}
+}
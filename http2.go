@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+)
+
+// conn_tracker counts the requests sent over each connection, and the
+// responses per protocol, to show how HTTP/2 multiplexes requests as
+// streams over few connections.
+type conn_tracker struct {
+	mu        sync.Mutex
+	streams   map[string]int64 // by connection
+	protocols map[string]int64
+}
+
+func new_conn_tracker() *conn_tracker {
+	return &conn_tracker{streams: make(map[string]int64), protocols: make(map[string]int64)}
+}
+
+// trace returns the client trace hook feeding the tracker.
+func (ct *conn_tracker) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			key := info.Conn.LocalAddr().String() + "-" + info.Conn.RemoteAddr().String()
+			ct.mu.Lock()
+			ct.streams[key]++
+			ct.mu.Unlock()
+		},
+	}
+}
+
+// record_protocol counts a response received with protocol proto.
+func (ct *conn_tracker) record_protocol(proto string) {
+	ct.mu.Lock()
+	ct.protocols[proto]++
+	ct.mu.Unlock()
+}
+
+// http2_report holds the connection usage of an HTTP/2 run.
+type http2_report struct {
+	Connections    int              `json:"connections"`
+	StreamsPerConn float64          `json:"mean_streams_per_connection"`
+	MaxStreams     int64            `json:"max_streams_per_connection"`
+	Protocols      map[string]int64 `json:"protocols"`
+}
+
+func (ct *conn_tracker) report() *http2_report {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	r := &http2_report{Connections: len(ct.streams), Protocols: ct.protocols}
+	var total int64
+	for _, n := range ct.streams {
+		total += n
+		r.MaxStreams = max(r.MaxStreams, n)
+	}
+	if r.Connections > 0 {
+		r.StreamsPerConn = float64(total) / float64(r.Connections)
+	}
+	return r
+}
+
+// print_http2 writes the HTTP/2 block of the summary.
+func print_http2(w io.Writer, r *http2_report) {
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(w, "HTTP/2: %d connections, %.1f streams per connection (max %d), responses:", r.Connections, r.StreamsPerConn, r.MaxStreams)
+	for _, proto := range slices.Sorted(maps.Keys(r.Protocols)) {
+		fmt.Fprintf(w, " %s %d", proto, r.Protocols[proto])
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+type junit_failure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junit_testcase struct {
+	Name      string         `xml:"name,attr"`
+	Classname string         `xml:"classname,attr"`
+	Time      float64        `xml:"time,attr"`
+	Failure   *junit_failure `xml:"failure,omitempty"`
+}
+
+type junit_testsuite struct {
+	XMLName   xml.Name         `xml:"testsuite"`
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Time      float64          `xml:"time,attr"`
+	Timestamp string           `xml:"timestamp,attr"`
+	Testcases []junit_testcase `xml:"testcase"`
+}
+
+type junit_testsuites struct {
+	XMLName xml.Name `xml:"testsuites"`
+	Suites  []junit_testsuite
+}
+
+// write_junit writes the threshold assertions of a run as a JUnit XML
+// report, one test case per assertion.
+func write_junit(w io.Writer, r *report) error {
+	suite := junit_testsuite{
+		Name:      "hammer " + r.Config.Method + " " + r.Config.URL,
+		Tests:     len(r.Assertions),
+		Time:      r.Duration,
+		Timestamp: r.Start.Format("2006-01-02T15:04:05"),
+	}
+	for _, a := range r.Assertions {
+		tc := junit_testcase{Name: a.Name + " " + a.Limit, Classname: "hammer.thresholds", Time: r.Duration}
+		if !a.Passed {
+			suite.Failures++
+			tc.Failure = &junit_failure{Message: a.Name + " is " + a.Actual, Text: a.Name + " is " + a.Actual + ", expected " + a.Limit}
+		}
+		suite.Testcases = append(suite.Testcases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junit_testsuites{Suites: []junit_testsuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
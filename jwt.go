@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jwt_signer builds signed JWTs from a claims template, whose {{iat}},
+// {{nbf}}, {{exp}}, {{jti}} and {{user}} placeholders are replaced by the
+// issue, not before and expiry times, a random token ID and the number of
+// the virtual user, and {{.NAME}} ones by the NAME column of the -data row
+// of the request (or virtual user), as with -template.
+type jwt_signer struct {
+	alg      string // RS256, ES256, EdDSA or HS256
+	key      any    // crypto.Signer, or []byte for HS256
+	header   string // encoded
+	claims   string
+	ttl      time.Duration
+	per_user bool    // one token per virtual user rather than per request
+	data     *feeder // nil without -data
+}
+
+// new_jwt_signer reads the signing key, a PEM private key (RSA, EC P-256
+// or Ed25519) or else an HMAC secret, and the claims template.
+func new_jwt_signer(key_file, claims_file string, ttl time.Duration, data *feeder) (*jwt_signer, error) {
+	b, err := os.ReadFile(key_file)
+	if err != nil {
+		return nil, err
+	}
+	s := &jwt_signer{ttl: ttl, data: data, alg: "HS256", key: []byte(strings.TrimSpace(string(b)))}
+	if block, _ := pem.Decode(b); block != nil {
+		var key any
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key_file, err)
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			s.alg = "RS256"
+		case *ecdsa.PrivateKey:
+			if k.Curve.Params().BitSize != 256 {
+				return nil, fmt.Errorf("%s: only P-256 EC keys are supported", key_file)
+			}
+			s.alg = "ES256"
+		case ed25519.PrivateKey:
+			s.alg = "EdDSA"
+		default:
+			return nil, fmt.Errorf("%s: unsupported key type %T", key_file, key)
+		}
+		s.key = key
+	}
+	s.header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + s.alg + `","typ":"JWT"}`))
+	claims := `{"iat":{{iat}},"exp":{{exp}},"jti":"{{jti}}"}`
+	if claims_file != "" {
+		if b, err = os.ReadFile(claims_file); err != nil {
+			return nil, err
+		}
+		claims = strings.TrimSpace(string(b))
+	}
+	s.claims = claims
+	var row []string
+	if data != nil {
+		row = data.rows[0]
+	}
+	if _, err := s.token(0, row); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// token returns a new JWT for virtual user user and -data row row.
+func (s *jwt_signer) token(user int, row []string) (string, error) {
+	claims, err := s.expand_claims(user, row)
+	if err != nil {
+		return "", err
+	}
+	input := s.header + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	sig, err := s.sign(input)
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// expand_claims returns the claims of a new JWT for virtual user user and
+// -data row row.
+func (s *jwt_signer) expand_claims(user int, row []string) (string, error) {
+	now := time.Now().Unix()
+	jti := make([]byte, 16)
+	rand.Read(jti)
+	claims := strings.NewReplacer(
+		"{{iat}}", strconv.FormatInt(now, 10),
+		"{{nbf}}", strconv.FormatInt(now, 10),
+		"{{exp}}", strconv.FormatInt(now+int64(s.ttl/time.Second), 10),
+		"{{jti}}", hex.EncodeToString(jti),
+		"{{user}}", strconv.Itoa(user),
+	).Replace(s.claims)
+	claims, err := expand(claims, &tmpl_vars{data: s.data, row: row})
+	if err != nil {
+		return "", err
+	}
+	if !json.Valid([]byte(claims)) {
+		return "", errorString("JWT claims are not valid JSON once expanded: " + claims)
+	}
+	return claims, nil
+}
+
+func (s *jwt_signer) sign(input string) ([]byte, error) {
+	digest := sha256.Sum256([]byte(input))
+	switch k := s.key.(type) {
+	case []byte:
+		return hmac_sha256(k, input), nil
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		// JWS wants r and s concatenated, not ASN.1
+		r, sv, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		sv.FillBytes(sig[32:])
+		return sig, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, []byte(input)), nil
+	}
+	return nil, errorString("Unsupported JWT key")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the Prometheus latency histogram buckets, in seconds
+var metrics_buckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics publishes the statistics of the run in progress in Prometheus
+// exposition format. It is updated by the live sampler, so figures lag by
+// up to one sampling interval.
+type metrics struct {
+	mu    sync.Mutex
+	total *stats
+}
+
+func new_metrics() *metrics {
+	return &metrics{total: new_stats()}
+}
+
+// sample is the sample_hook accumulating the interval statistics.
+func (m *metrics) sample(elapsed, interval time.Duration, s *stats) {
+	m.mu.Lock()
+	m.total.merge(s)
+	m.mu.Unlock()
+}
+
+// serve starts the HTTP listener publishing /metrics.
+func (m *metrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, mux))
+	}()
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.total
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP hammer_requests_total Requests completed.")
+	fmt.Fprintln(w, "# TYPE hammer_requests_total counter")
+	fmt.Fprintf(w, "hammer_requests_total %d\n", st.requests)
+
+	fmt.Fprintln(w, "# HELP hammer_errors_total Failed requests per error category.")
+	fmt.Fprintln(w, "# TYPE hammer_errors_total counter")
+	for _, kind := range error_kinds {
+		fmt.Fprintf(w, "hammer_errors_total{kind=%q} %d\n", kind, st.failures[kind])
+	}
+
+	fmt.Fprintln(w, "# HELP hammer_responses_total Responses per HTTP status code.")
+	fmt.Fprintln(w, "# TYPE hammer_responses_total counter")
+	codes := make([]int, 0, len(st.codes))
+	for code := range st.codes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "hammer_responses_total{code=\"%d\"} %d\n", code, st.codes[code])
+	}
+
+	fmt.Fprintln(w, "# HELP hammer_received_bytes_total Response body bytes received.")
+	fmt.Fprintln(w, "# TYPE hammer_received_bytes_total counter")
+	fmt.Fprintf(w, "hammer_received_bytes_total %d\n", st.bytes)
+
+	fmt.Fprintln(w, "# HELP hammer_request_duration_seconds Request latency.")
+	fmt.Fprintln(w, "# TYPE hammer_request_duration_seconds histogram")
+	for _, le := range metrics_buckets {
+		fmt.Fprintf(w, "hammer_request_duration_seconds_bucket{le=\"%g\"} %d\n", le, st.latency.count_at_or_below(int64(le*1e6)))
+	}
+	fmt.Fprintf(w, "hammer_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", st.latency.count())
+	fmt.Fprintf(w, "hammer_request_duration_seconds_sum %g\n", st.latency.mean()*float64(st.latency.count())/1e6)
+	fmt.Fprintf(w, "hammer_request_duration_seconds_count %d\n", st.latency.count())
+}
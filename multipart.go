@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// form_field is a multipart/form-data field, of a file if path is set.
+type form_field struct {
+	name    string
+	value   string // file content if path is set
+	path    string
+	content string // MIME type of the file
+}
+
+// form type: the -form fields, implementing the flag.Value interface.
+type form []form_field
+
+func (f *form) String() string {
+	return fmt.Sprint(*f)
+}
+
+// Set parses a name=value field, or a name=@path file field whose content
+// is read at once.
+func (f *form) Set(value string) error {
+	name, v, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return errorString("Form field format must be `name=value' or `name=@file'")
+	}
+	ff := form_field{name: name, value: v}
+	if path, ok := strings.CutPrefix(v, "@"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		ff.path, ff.value = path, string(b)
+		ff.content = mime.TypeByExtension(filepath.Ext(path))
+		if ff.content == "" {
+			ff.content = "application/octet-stream"
+		}
+	}
+	*f = append(*f, ff)
+	return nil
+}
+
+// quote_escaper escapes the quoted parameters of Content-Disposition
+// headers, as multipart.Writer.CreateFormFile does: form parsers seldom
+// accept the RFC 2231 encoding of mime.FormatMediaType.
+var quote_escaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// build returns a multipart/form-data body of the fields, with a random
+// boundary, and its content type.
+func (f form) build() (string, string) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	for _, ff := range f {
+		if ff.path == "" {
+			mw.WriteField(ff.name, ff.value)
+			continue
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quote_escaper.Replace(ff.name), quote_escaper.Replace(filepath.Base(ff.path))))
+		h.Set("Content-Type", ff.content)
+		w, _ := mw.CreatePart(h)
+		w.Write([]byte(ff.value))
+	}
+	mw.Close()
+	return b.String(), mw.FormDataContentType()
+}
@@ -0,0 +1,759 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"reflect"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// openapi_spec is the part of an OpenAPI 3 document, in JSON, describing
+// the operations. YAML documents must be converted first.
+type openapi_spec struct {
+	OpenAPI string `json:"openapi"`
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"` // path items, by method
+	Components struct {
+		Schemas       map[string]*openapi_schema    `json:"schemas"`
+		Parameters    map[string]*openapi_parameter `json:"parameters"`
+		RequestBodies map[string]*openapi_body      `json:"requestBodies"`
+		Responses     map[string]*openapi_body      `json:"responses"`
+	} `json:"components"`
+}
+
+type openapi_operation struct {
+	OperationID string                   `json:"operationId"`
+	Tags        []string                 `json:"tags"`
+	Parameters  []*openapi_parameter     `json:"parameters"`
+	RequestBody *openapi_body            `json:"requestBody"`
+	Responses   map[string]*openapi_body `json:"responses"`
+}
+
+type openapi_parameter struct {
+	Ref      string          `json:"$ref"`
+	Name     string          `json:"name"`
+	In       string          `json:"in"` // path, query, header or cookie
+	Required bool            `json:"required"`
+	Schema   *openapi_schema `json:"schema"`
+}
+
+// openapi_body is a request body or a response, by media type.
+type openapi_body struct {
+	Ref      string `json:"$ref"`
+	Required bool   `json:"required"`
+	Content  map[string]struct {
+		Schema *openapi_schema `json:"schema"`
+	} `json:"content"`
+}
+
+// openapi_schema is the subset of JSON Schema generating values and
+// checking responses. Formats are generated but not checked.
+type openapi_schema struct {
+	Ref        string                     `json:"$ref"`
+	Type       any                        `json:"type"` // name, or list of names (OpenAPI 3.1)
+	Format     string                     `json:"format"`
+	Enum       []any                      `json:"enum"`
+	Nullable   bool                       `json:"nullable"`
+	Properties map[string]*openapi_schema `json:"properties"`
+	Required   []string                   `json:"required"`
+	Items      *openapi_schema            `json:"items"`
+	AllOf      []*openapi_schema          `json:"allOf"`
+	AnyOf      []*openapi_schema          `json:"anyOf"`
+	OneOf      []*openapi_schema          `json:"oneOf"`
+	Minimum    *float64                   `json:"minimum"`
+	Maximum    *float64                   `json:"maximum"`
+	MinLength  *int                       `json:"minLength"`
+	MaxLength  *int                       `json:"maxLength"`
+	MinItems   *int                       `json:"minItems"`
+	MaxItems   *int                       `json:"maxItems"`
+	Pattern    string                     `json:"pattern"`
+	re         *regexp.Regexp             // compiled Pattern
+	syntax     *syntax.Regexp             // parsed Pattern, generating values
+}
+
+// Methods of the path items, in the order of the scenario
+var openapi_methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openapi_depth is the nesting depth of generated values beyond which
+// optional properties and array items are left out.
+const openapi_depth = 4
+
+// api_operation generates the requests of an OpenAPI operation, and checks
+// its responses if validate is set.
+type api_operation struct {
+	name      string
+	base      string // server URL
+	path      string // with {parameter} templates
+	params    []*openapi_parameter
+	body      *openapi_schema // nil if no body is sent
+	form      bool            // body URL-encoded rather than JSON
+	responses map[string]*openapi_body
+	validate  bool
+}
+
+// schema_error is a response not matching the specification.
+type schema_error string
+
+func (e schema_error) Error() string {
+	return "Unexpected response of " + string(e)
+}
+
+// read_openapi returns the weighted scenario of the operations of an
+// OpenAPI specification, sent to base or else to its first server.
+// Operations are selected by a comma-separated list of NAME=WEIGHT (or
+// NAME, of weight 1), NAME being an operationId or a tag; all are if the
+// list is empty.
+func read_openapi(file, base, selection string, validate bool) (*scenario, error) {
+	var sp openapi_spec
+	if err := read_json(file, &sp); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(sp.OpenAPI, "3.") {
+		return nil, fmt.Errorf("%s: not an OpenAPI 3 document", file)
+	}
+	if base == "" && len(sp.Servers) > 0 {
+		base = sp.Servers[0].URL
+	}
+	if u, err := neturl.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Contains(base, "{") {
+		return nil, fmt.Errorf("%s: no absolute server URL, set -openapi-base", file)
+	}
+	weights, order, err := parse_weights(selection)
+	if err != nil {
+		return nil, err
+	}
+	r := &openapi_resolver{spec: &sp, seen: make(map[*openapi_schema]bool)}
+
+	s := &scenario{}
+	selected := make(map[string]bool)
+	paths := make([]string, 0, len(sp.Paths))
+	for path := range sp.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		item := sp.Paths[path]
+		var shared []*openapi_parameter
+		if raw, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(raw, &shared); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", file, path, err)
+			}
+		}
+		for _, method := range openapi_methods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+			var op openapi_operation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, fmt.Errorf("%s: %s %s: %v", file, method, path, err)
+			}
+			name := op.OperationID
+			if name == "" {
+				name = strings.ToUpper(method) + " " + path
+			}
+			weight := 1.0
+			if len(weights) > 0 {
+				key, found := "", false
+				for _, k := range append([]string{op.OperationID}, op.Tags...) {
+					if _, found = weights[k]; found {
+						key = k
+						break
+					}
+				}
+				if !found {
+					continue
+				}
+				weight, selected[key] = weights[key], true
+			}
+			ao, err := r.operation(name, path, shared, &op)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", file, name, err)
+			}
+			if ao == nil {
+				continue
+			}
+			ao.base, ao.validate = strings.TrimSuffix(base, "/"), validate
+			u, err := neturl.Parse(ao.base + path)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", file, name, err)
+			}
+			e := endpoint{name: name, method: strings.ToUpper(method), url: u, raw: u.String(), weight: weight, op: ao}
+			if ao.body != nil {
+				content_type := "application/json"
+				if ao.form {
+					content_type = "application/x-www-form-urlencoded"
+				}
+				e.hdr = append(e.hdr, hfield{"Content-Type", content_type})
+			}
+			s.endpoints = append(s.endpoints, e)
+		}
+	}
+	for _, name := range order {
+		if !selected[name] {
+			return nil, fmt.Errorf("%s: no operation or tag named %q", file, name)
+		}
+	}
+	if len(s.endpoints) == 0 {
+		return nil, fmt.Errorf("%s: no operation found", file)
+	}
+	s.prepare()
+	return s, nil
+}
+
+// openapi_resolver replaces the references to components of a
+// specification ($ref) by the components themselves, and compiles the
+// patterns of the schemas.
+type openapi_resolver struct {
+	spec *openapi_spec
+	seen map[*openapi_schema]bool
+}
+
+// component returns the component of a reference, which must be local.
+func component[T any](components map[string]*T, kind, ref string) (*T, error) {
+	c, ok := components[strings.TrimPrefix(ref, "#/components/"+kind+"/")]
+	if !ok || !strings.HasPrefix(ref, "#/components/"+kind+"/") {
+		return nil, fmt.Errorf("Unresolved reference %q", ref)
+	}
+	return c, nil
+}
+
+func (r *openapi_resolver) schema(p **openapi_schema) error {
+	for depth := 0; *p != nil && (*p).Ref != ""; depth++ {
+		s, err := component(r.spec.Components.Schemas, "schemas", (*p).Ref)
+		if err != nil {
+			return err
+		}
+		if depth == 8 {
+			return fmt.Errorf("Circular reference %q", (*p).Ref)
+		}
+		*p = s
+	}
+	s := *p
+	if s == nil || r.seen[s] {
+		return nil
+	}
+	r.seen[s] = true
+	if s.Pattern != "" {
+		var err error
+		if s.re, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+		if s.syntax, err = syntax.Parse(s.Pattern, syntax.Perl); err != nil {
+			return err
+		}
+	}
+	children := []**openapi_schema{&s.Items}
+	for _, list := range [][]*openapi_schema{s.AllOf, s.AnyOf, s.OneOf} {
+		for i := range list {
+			children = append(children, &list[i])
+		}
+	}
+	for name := range s.Properties {
+		child := s.Properties[name]
+		if err := r.schema(&child); err != nil {
+			return err
+		}
+		s.Properties[name] = child
+	}
+	for _, c := range children {
+		if err := r.schema(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *openapi_resolver) body(p **openapi_body, kind string, components map[string]*openapi_body) error {
+	if *p != nil && (*p).Ref != "" {
+		b, err := component(components, kind, (*p).Ref)
+		if err != nil {
+			return err
+		}
+		*p = b
+	}
+	if *p == nil {
+		return nil
+	}
+	for media, c := range (*p).Content {
+		if err := r.schema(&c.Schema); err != nil {
+			return err
+		}
+		(*p).Content[media] = c
+	}
+	return nil
+}
+
+// operation returns the operation sent to path, with the parameters shared
+// by its path item, or nil if its request body cannot be generated.
+func (r *openapi_resolver) operation(name, path string, shared []*openapi_parameter, op *openapi_operation) (*api_operation, error) {
+	ao := &api_operation{name: name, path: path, responses: op.Responses}
+	for _, list := range [][]*openapi_parameter{shared, op.Parameters} {
+		for _, p := range list {
+			if p.Ref != "" {
+				var err error
+				if p, err = component(r.spec.Components.Parameters, "parameters", p.Ref); err != nil {
+					return nil, err
+				}
+			}
+			if err := r.schema(&p.Schema); err != nil {
+				return nil, err
+			}
+			// Operation parameters override those of the path item
+			ao.params = slices.DeleteFunc(ao.params, func(q *openapi_parameter) bool { return q.Name == p.Name && q.In == p.In })
+			ao.params = append(ao.params, p)
+		}
+	}
+	if err := r.body(&op.RequestBody, "requestBodies", r.spec.Components.RequestBodies); err != nil {
+		return nil, err
+	}
+	for code := range ao.responses {
+		b := ao.responses[code]
+		if err := r.body(&b, "responses", r.spec.Components.Responses); err != nil {
+			return nil, err
+		}
+		ao.responses[code] = b
+	}
+	if rb := op.RequestBody; rb != nil {
+		for media, c := range rb.Content {
+			if is_json(media) {
+				ao.body, ao.form = c.Schema, false
+				break
+			}
+			if media == "application/x-www-form-urlencoded" {
+				ao.body, ao.form = c.Schema, true
+			}
+		}
+		if ao.body == nil && rb.Required {
+			log.Printf("Left out OpenAPI operation %q: its body is neither JSON nor URL-encoded", name)
+			return nil, nil
+		}
+	}
+	return ao, nil
+}
+
+// is_json tells whether a media type is JSON.
+func is_json(media string) bool {
+	return media == "application/json" || strings.HasSuffix(media, "+json")
+}
+
+// generate returns the URL and body of a new request of the operation,
+// with random values of its parameters, and sets its header and cookie
+// parameters.
+// Optional parameters are sent half of the time.
+func (o *api_operation) generate(h http.Header) (*neturl.URL, string) {
+	path := o.path
+	query := neturl.Values{}
+	var cookies []string
+	for _, p := range o.params {
+		if !p.Required && p.In != "path" && rand.Intn(2) == 0 {
+			continue
+		}
+		values := param_values(generate_value(p.Schema, 0))
+		switch p.In {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", neturl.PathEscape(strings.Join(values, ",")))
+		case "query":
+			query[p.Name] = values
+		case "header":
+			h.Set(p.Name, strings.Join(values, ","))
+		case "cookie":
+			cookies = append(cookies, p.Name+"="+strings.Join(values, ","))
+		}
+	}
+	if len(cookies) > 0 {
+		h.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	u, err := neturl.Parse(o.base + path)
+	if err != nil {
+		u, _ = neturl.Parse(o.base)
+	}
+	u.RawQuery = query.Encode()
+	if o.body == nil {
+		return u, ""
+	}
+	v := generate_value(o.body, 0)
+	if o.form {
+		form := neturl.Values{}
+		if obj, ok := v.(map[string]any); ok {
+			for name, field := range obj {
+				form[name] = param_values(field)
+			}
+		}
+		return u, form.Encode()
+	}
+	b, _ := json.Marshal(v)
+	return u, string(b)
+}
+
+// param_values returns the values of a parameter: one per element of an
+// array (comma-separated in paths and headers), objects in JSON.
+func param_values(v any) []string {
+	list, ok := v.([]any)
+	if !ok {
+		list = []any{v}
+	}
+	values := make([]string, len(list))
+	for i, x := range list {
+		switch x := x.(type) {
+		case string:
+			values[i] = x
+		case map[string]any, []any:
+			b, _ := json.Marshal(x)
+			values[i] = string(b)
+		case nil:
+		default:
+			values[i] = fmt.Sprint(x)
+		}
+	}
+	return values
+}
+
+// types returns the type names of a schema.
+func (s *openapi_schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var names []string
+		for _, x := range t {
+			if name, ok := x.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// generate_value returns a random value valid for schema s, at a nesting
+// depth.
+func generate_value(s *openapi_schema, depth int) any {
+	switch {
+	case s == nil:
+		return random_word(8)
+	case len(s.Enum) > 0:
+		return s.Enum[rand.Intn(len(s.Enum))]
+	case len(s.AllOf) > 0:
+		obj := make(map[string]any)
+		for _, part := range s.AllOf {
+			if m, ok := generate_value(part, depth).(map[string]any); ok {
+				for k, v := range m {
+					obj[k] = v
+				}
+			}
+		}
+		return obj
+	case len(s.OneOf) > 0:
+		return generate_value(s.OneOf[rand.Intn(len(s.OneOf))], depth)
+	case len(s.AnyOf) > 0:
+		return generate_value(s.AnyOf[rand.Intn(len(s.AnyOf))], depth)
+	case depth > 4*openapi_depth:
+		return nil
+	}
+	kind := "string"
+	if types := slices.DeleteFunc(s.types(), func(t string) bool { return t == "null" }); len(types) > 0 {
+		kind = types[0]
+	} else if s.Properties != nil {
+		kind = "object"
+	} else if s.Items != nil {
+		kind = "array"
+	}
+	switch kind {
+	case "object":
+		obj := make(map[string]any)
+		for name, p := range s.Properties {
+			if depth < openapi_depth || slices.Contains(s.Required, name) {
+				obj[name] = generate_value(p, depth+1)
+			}
+		}
+		return obj
+	case "array":
+		lo, hi := 0, 3
+		if s.MinItems != nil {
+			lo = *s.MinItems
+		}
+		hi = max(hi, lo)
+		if s.MaxItems != nil {
+			hi = min(hi, *s.MaxItems)
+		}
+		n := lo
+		if depth < openapi_depth && hi > lo {
+			n += rand.Intn(hi - lo + 1)
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = generate_value(s.Items, depth+1)
+		}
+		return list
+	case "integer", "number":
+		lo, hi := 0.0, 1000.0
+		switch {
+		case s.Minimum != nil && s.Maximum != nil:
+			lo, hi = *s.Minimum, *s.Maximum
+		case s.Minimum != nil:
+			lo, hi = *s.Minimum, *s.Minimum+1000
+		case s.Maximum != nil:
+			lo, hi = math.Min(0, *s.Maximum-1000), *s.Maximum
+		}
+		if kind == "number" {
+			return lo + rand.Float64()*(hi-lo)
+		}
+		ilo, ihi := int64(math.Ceil(lo)), int64(math.Floor(hi))
+		if ihi < ilo {
+			return ilo
+		}
+		return ilo + rand.Int63n(ihi-ilo+1)
+	case "boolean":
+		return rand.Intn(2) == 0
+	case "null":
+		return nil
+	}
+	if s.syntax != nil {
+		var b strings.Builder
+		pattern_value(s.syntax, &b)
+		return b.String()
+	}
+	switch s.Format {
+	case "date-time":
+		return time.Now().Add(-time.Duration(rand.Int63n(int64(365 * 24 * time.Hour)))).UTC().Format(time.RFC3339)
+	case "date":
+		return time.Now().AddDate(0, 0, -rand.Intn(365)).Format(time.DateOnly)
+	case "uuid":
+		return new_uuid()
+	case "email":
+		return random_word(8) + "@example.com"
+	case "uri", "url":
+		return "https://example.com/" + random_word(8)
+	case "ipv4":
+		return fmt.Sprintf("10.%d.%d.%d", rand.Intn(256), rand.Intn(256), rand.Intn(256))
+	case "byte":
+		b := make([]byte, 12)
+		rand.Read(b)
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	lo, hi := 8, 8
+	if s.MinLength != nil {
+		lo, hi = *s.MinLength, max(hi, *s.MinLength)
+	}
+	if s.MaxLength != nil {
+		lo, hi = min(lo, *s.MaxLength), min(hi, *s.MaxLength)
+	}
+	return random_word(lo + rand.Intn(hi-lo+1))
+}
+
+// pattern_value writes to b a random string matching the parsed pattern re.
+// Unbounded repetitions are repeated at most 3 more times than their
+// minimum, and printable ASCII characters are preferred in classes.
+func pattern_value(re *syntax.Regexp, b *strings.Builder) {
+	repeat := func(lo, hi int) {
+		if hi < 0 {
+			hi = lo + 3
+		}
+		for n := lo + rand.Intn(hi-lo+1); n > 0; n-- {
+			pattern_value(re.Sub[0], b)
+		}
+	}
+	switch re.Op {
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		b.WriteRune(class_rune(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteByte(byte('a' + rand.Intn(26)))
+	case syntax.OpCapture:
+		pattern_value(re.Sub[0], b)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			pattern_value(sub, b)
+		}
+	case syntax.OpAlternate:
+		pattern_value(re.Sub[rand.Intn(len(re.Sub))], b)
+	case syntax.OpStar:
+		repeat(0, -1)
+	case syntax.OpPlus:
+		repeat(1, -1)
+	case syntax.OpQuest:
+		repeat(0, 1)
+	case syntax.OpRepeat:
+		repeat(re.Min, re.Max)
+	}
+	// Anchors and word boundaries match without characters
+}
+
+// class_rune returns a random rune of a character class, given as pairs
+// of bounds: a printable ASCII one if the class has some.
+func class_rune(ranges []rune) rune {
+	var printable []rune
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for r := max(ranges[i], ' '); r <= min(ranges[i+1], '~'); r++ {
+			printable = append(printable, r)
+		}
+	}
+	if len(printable) > 0 {
+		return printable[rand.Intn(len(printable))]
+	}
+	if len(ranges) < 2 {
+		return 'a' // empty class, matching nothing
+	}
+	i := 2 * rand.Intn(len(ranges)/2)
+	return ranges[i] + rand.Int31n(min(ranges[i+1]-ranges[i]+1, 256))
+}
+
+// random_word returns n random lowercase letters.
+func random_word(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + rand.Intn(26))
+	}
+	return string(b)
+}
+
+// check returns an error if a response does not match the specification:
+// its status is not declared, or its JSON body does not match the schema
+// of its content type.
+func (o *api_operation) check(resp *http.Response, body []byte) error {
+	code := strconv.Itoa(resp.StatusCode)
+	r, ok := o.responses[code]
+	if !ok {
+		r, ok = o.responses[code[:1]+"XX"]
+	}
+	if !ok {
+		r, ok = o.responses["default"]
+	}
+	if !ok {
+		return schema_error(fmt.Sprintf("%s: undeclared status %d", o.name, resp.StatusCode))
+	}
+	if r == nil || len(r.Content) == 0 {
+		return nil
+	}
+	media, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	c, ok := r.Content[media]
+	if !ok {
+		if c, ok = r.Content["*/*"]; !ok {
+			return schema_error(fmt.Sprintf("%s: undeclared content type %q of status %d", o.name, media, resp.StatusCode))
+		}
+	}
+	if c.Schema == nil || !is_json(media) {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return schema_error(fmt.Sprintf("%s: invalid JSON body of status %d", o.name, resp.StatusCode))
+	}
+	if msg := check_value(c.Schema, v, "body"); msg != "" {
+		return schema_error(fmt.Sprintf("%s: status %d: %s", o.name, resp.StatusCode, msg))
+	}
+	return nil
+}
+
+// json_type returns the schema type name of a decoded JSON value.
+func json_type(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "null"
+}
+
+// check_value returns why the value at path of a JSON document does not
+// match schema s, or "" if it does.
+func check_value(s *openapi_schema, v any, path string) string {
+	if s == nil {
+		return ""
+	}
+	kind := json_type(v)
+	if types := s.types(); len(types) > 0 || s.Nullable {
+		if s.Nullable {
+			types = append(types, "null")
+		}
+		if !slices.Contains(types, kind) && !(kind == "integer" && slices.Contains(types, "number")) {
+			return fmt.Sprintf("%s: %s instead of %s", path, kind, strings.Join(types, " or "))
+		}
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(x any) bool { return reflect.DeepEqual(x, v) }) {
+		return fmt.Sprintf("%s: value not in enum", path)
+	}
+	for _, part := range s.AllOf {
+		if msg := check_value(part, v, path); msg != "" {
+			return msg
+		}
+	}
+	if len(s.AnyOf) > 0 && !slices.ContainsFunc(s.AnyOf, func(x *openapi_schema) bool { return check_value(x, v, path) == "" }) {
+		return fmt.Sprintf("%s: matches none of anyOf", path)
+	}
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, x := range s.OneOf {
+			if check_value(x, v, path) == "" {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Sprintf("%s: matches %d of oneOf", path, matches)
+		}
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Sprintf("%s: missing required %s", path, name)
+			}
+		}
+		for name, p := range s.Properties {
+			if x, ok := v[name]; ok {
+				if msg := check_value(p, x, path+"."+name); msg != "" {
+					return msg
+				}
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems || s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Sprintf("%s: %d items out of bounds", path, len(v))
+		}
+		for i, x := range v {
+			if msg := check_value(s.Items, x, path+"."+strconv.Itoa(i)); msg != "" {
+				return msg
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength || s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Sprintf("%s: length %d out of bounds", path, n)
+		}
+		if s.re != nil && !s.re.MatchString(v) {
+			return fmt.Sprintf("%s: does not match pattern %s", path, s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum || s.Maximum != nil && v > *s.Maximum {
+			return fmt.Sprintf("%s: %v out of bounds", path, v)
+		}
+	}
+	return ""
+}
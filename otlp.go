@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlp exports the statistics of the run to an OpenTelemetry collector,
+// using OTLP over HTTP with JSON encoding. Metrics are cumulative since the
+// beginning of the run.
+type otlp struct {
+	endpoint string
+	every    time.Duration
+	resource []otlp_attribute
+	client   *http.Client
+	total    *stats
+	begin    time.Time
+	since    time.Duration // time of the last export
+}
+
+type otlp_value struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlp_attribute struct {
+	Key   string     `json:"key"`
+	Value otlp_value `json:"value"`
+}
+
+type otlp_point struct {
+	Attributes     []otlp_attribute `json:"attributes,omitempty"`
+	StartTime      string           `json:"startTimeUnixNano"`
+	Time           string           `json:"timeUnixNano"`
+	AsInt          string           `json:"asInt,omitempty"`
+	Count          string           `json:"count,omitempty"`
+	Sum            *float64         `json:"sum,omitempty"`
+	BucketCounts   []string         `json:"bucketCounts,omitempty"`
+	ExplicitBounds []float64        `json:"explicitBounds,omitempty"`
+}
+
+type otlp_data struct {
+	Temporality int          `json:"aggregationTemporality"`
+	Monotonic   bool         `json:"isMonotonic,omitempty"`
+	Points      []otlp_point `json:"dataPoints"`
+}
+
+type otlp_metric struct {
+	Name      string     `json:"name"`
+	Unit      string     `json:"unit"`
+	Sum       *otlp_data `json:"sum,omitempty"`
+	Histogram *otlp_data `json:"histogram,omitempty"`
+}
+
+// Cumulative aggregation temporality
+const otlp_cumulative = 2
+
+// new_otlp creates an exporter to the collector at endpoint (e.g.
+// http://localhost:4318), tagging metrics with the test name and target.
+func new_otlp(endpoint string, every time.Duration, test_name, target string) *otlp {
+	resource := []otlp_attribute{
+		{"service.name", otlp_value{"hammer"}},
+		{"hammer.target", otlp_value{target}},
+	}
+	if test_name != "" {
+		resource = append(resource, otlp_attribute{"hammer.test_name", otlp_value{test_name}})
+	}
+	return &otlp{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/metrics",
+		every:    every,
+		resource: resource,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		total:    new_stats(),
+	}
+}
+
+func otlp_time(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// sample is the sample_hook accumulating interval statistics and exporting
+// them when due.
+func (o *otlp) sample(elapsed, interval time.Duration, s *stats) {
+	o.total.merge(s)
+	if o.begin.IsZero() {
+		o.begin = time.Now().Add(-elapsed)
+	}
+	if elapsed-o.since+interval/2 < o.every {
+		return
+	}
+	o.since = elapsed
+	o.flush()
+}
+
+// flush exports the statistics accumulated so far. It is called once more
+// when the run is over, for the last intervals.
+func (o *otlp) flush() {
+	if err := o.export(); err != nil {
+		log_error("OTLP export", err)
+	}
+}
+
+func (o *otlp) counter(name, unit string, points ...otlp_point) otlp_metric {
+	return otlp_metric{Name: name, Unit: unit, Sum: &otlp_data{Temporality: otlp_cumulative, Monotonic: true, Points: points}}
+}
+
+// export sends the accumulated statistics to the collector.
+func (o *otlp) export() error {
+	if o.begin.IsZero() {
+		o.begin = time.Now()
+	}
+	start, now := otlp_time(o.begin), otlp_time(time.Now())
+	point := func(v int64, attrs ...otlp_attribute) otlp_point {
+		return otlp_point{Attributes: attrs, StartTime: start, Time: now, AsInt: strconv.FormatInt(v, 10)}
+	}
+	st := o.total
+
+	var errors []otlp_point
+	for kind, n := range st.failures {
+		errors = append(errors, point(n, otlp_attribute{"error.type", otlp_value{kind}}))
+	}
+	var responses []otlp_point
+	for code, n := range st.codes {
+		responses = append(responses, point(n, otlp_attribute{"http.response.status_code", otlp_value{strconv.Itoa(code)}}))
+	}
+
+	// Latency histogram with the same buckets as the Prometheus metrics
+	counts := make([]string, len(metrics_buckets)+1)
+	var below int64
+	for i, le := range metrics_buckets {
+		n := st.latency.count_at_or_below(int64(le * 1e6))
+		counts[i] = strconv.FormatInt(n-below, 10)
+		below = n
+	}
+	counts[len(metrics_buckets)] = strconv.FormatInt(st.latency.count()-below, 10)
+	sum := st.latency.mean() * float64(st.latency.count()) / 1e6
+	duration := otlp_metric{Name: "hammer.request.duration", Unit: "s", Histogram: &otlp_data{
+		Temporality: otlp_cumulative,
+		Points: []otlp_point{{
+			StartTime:      start,
+			Time:           now,
+			Count:          strconv.FormatInt(st.latency.count(), 10),
+			Sum:            &sum,
+			BucketCounts:   counts,
+			ExplicitBounds: metrics_buckets,
+		}},
+	}}
+
+	metrics := []otlp_metric{
+		o.counter("hammer.requests", "{request}", point(st.requests)),
+		o.counter("hammer.received", "By", point(st.bytes)),
+		duration,
+	}
+	if len(errors) != 0 {
+		metrics = append(metrics, o.counter("hammer.errors", "{request}", errors...))
+	}
+	if len(responses) != 0 {
+		metrics = append(metrics, o.counter("hammer.responses", "{response}", responses...))
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": map[string]any{"attributes": o.resource},
+			"scopeMetrics": []any{map[string]any{
+				"scope":   map[string]string{"name": "hammer"},
+				"metrics": metrics,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Post(o.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP collector returned %s", resp.Status)
+	}
+	return nil
+}
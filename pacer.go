@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// pacer schedules requests at a fixed arrival rate. It is shared by all
+// workers: each call to wait hands out the next slot of the schedule,
+// independently of how long previous requests took (open loop).
+type pacer struct {
+	mu       sync.Mutex
+	interval time.Duration // mean interval between requests
+	poisson  bool          // exponentially distributed intervals
+	next     time.Time
+}
+
+// new_pacer creates a pacer issuing rate requests per second, at regular
+// intervals or following a Poisson process. A rate of 0 means no limit.
+func new_pacer(rate float64, poisson bool) *pacer {
+	p := &pacer{poisson: poisson}
+	p.set_rate(rate)
+	return p
+}
+
+func rate_interval(rate float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// set_rate changes the arrival rate, from the next slot on. If the
+// schedule is late, it restarts from now rather than catching up.
+func (p *pacer) set_rate(rate float64) {
+	p.mu.Lock()
+	p.interval = rate_interval(rate)
+	if now := time.Now(); p.next.Before(now) && !p.next.IsZero() {
+		p.next = now
+	}
+	p.mu.Unlock()
+}
+
+// rate returns the current arrival rate, 0 if unlimited.
+func (p *pacer) rate() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.interval == 0 {
+		return 0
+	}
+	return float64(time.Second) / float64(p.interval)
+}
+
+// restart drops the slots of the schedule already past, e.g. after a
+// pause.
+func (p *pacer) restart() {
+	p.mu.Lock()
+	if now := time.Now(); p.next.Before(now) {
+		p.next = now
+	}
+	p.mu.Unlock()
+}
+
+// start sets the time of the first slot of the schedule.
+func (p *pacer) start(t time.Time) {
+	p.mu.Lock()
+	p.next = t
+	p.mu.Unlock()
+}
+
+// wait blocks until the next slot of the schedule and returns its time.
+// It returns early if workers are asked to stop.
+func (p *pacer) wait() time.Time {
+	p.mu.Lock()
+	t := p.next
+	if p.poisson {
+		p.next = t.Add(time.Duration(rand.ExpFloat64() * float64(p.interval)))
+	} else {
+		p.next = t.Add(p.interval)
+	}
+	p.mu.Unlock()
+
+	pause(time.Until(t))
+	return t
+}
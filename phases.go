@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Request phases timed with -phases
+const (
+	phase_dns = iota
+	phase_connect
+	phase_tls
+	phase_wait // from the end of the request to the first response byte
+	phase_count
+)
+
+var phase_names = [phase_count]string{"DNS lookup", "TCP connect", "TLS handshake", "Server wait"}
+
+// phase_timer collects the phase timestamps of the request in progress of
+// one worker. Some of them are set from transport goroutines, hence the
+// mutex.
+type phase_timer struct {
+	mu        sync.Mutex
+	durations [phase_count]time.Duration
+	started   [phase_count]time.Time
+}
+
+func (pt *phase_timer) start(phase int) {
+	pt.mu.Lock()
+	pt.started[phase] = time.Now()
+	pt.mu.Unlock()
+}
+
+func (pt *phase_timer) done(phase int) {
+	pt.mu.Lock()
+	if !pt.started[phase].IsZero() {
+		pt.durations[phase] = time.Since(pt.started[phase])
+	}
+	pt.mu.Unlock()
+}
+
+// trace returns the client trace hooks feeding the timer.
+func (pt *phase_timer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { pt.start(phase_dns) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { pt.done(phase_dns) },
+		ConnectStart:         func(string, string) { pt.start(phase_connect) },
+		ConnectDone:          func(string, string, error) { pt.done(phase_connect) },
+		TLSHandshakeStart:    func() { pt.start(phase_tls) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { pt.done(phase_tls) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { pt.start(phase_wait) },
+		GotFirstResponseByte: func() { pt.done(phase_wait) },
+	}
+}
+
+// record adds the phases of the last request to s (unless nil) and resets
+// the timer. Phases which did not occur (e.g. connect on a reused
+// connection) are not recorded.
+func (pt *phase_timer) record(s *stats) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	for phase, d := range pt.durations {
+		if d > 0 && s != nil {
+			s.record_phase(phase, d)
+		}
+	}
+	pt.durations = [phase_count]time.Duration{}
+	pt.started = [phase_count]time.Time{}
+}
+
+// churn_report holds the connection setup figures of a run opening a new
+// connection for every request.
+type churn_report struct {
+	Connections int64           `json:"connections"`
+	Rate        float64         `json:"connections_per_second"`
+	Connect     latency_report  `json:"connect_ms"`
+	TLS         *latency_report `json:"tls_handshake_ms,omitempty"`
+}
+
+func new_churn_report(st *stats, elapsed float64) *churn_report {
+	h := st.phases[phase_connect]
+	if h == nil {
+		return &churn_report{}
+	}
+	r := &churn_report{Connections: h.count(), Connect: new_latency_report(h)}
+	if elapsed > 0 {
+		r.Rate = float64(r.Connections) / elapsed
+	}
+	if h := st.phases[phase_tls]; h != nil {
+		tls := new_latency_report(h)
+		r.TLS = &tls
+	}
+	return r
+}
+
+// print_churn writes the connection setup block of the summary.
+func print_churn(w io.Writer, r *churn_report) {
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(w, "Connections: %d opened, %.2f connections/s, connect p50 %.3f ms, p99 %.3f ms\n",
+		r.Connections, r.Rate, r.Connect.percentile(50), r.Connect.percentile(99))
+	if r.TLS != nil {
+		fmt.Fprintf(w, "TLS handshakes: p50 %.3f ms, p99 %.3f ms, max %.3f ms\n", r.TLS.percentile(50), r.TLS.percentile(99), r.TLS.Max)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+// pipeline_stats holds the pipelining statistics of one worker.
+type pipeline_stats struct {
+	connections int64
+	batches     int64
+	answered    int64 // responses read, over all batches
+	max_depth   int
+}
+
+func (p *pipeline_stats) merge(o *pipeline_stats) {
+	p.connections += o.connections
+	p.batches += o.batches
+	p.answered += o.answered
+	p.max_depth = max(p.max_depth, o.max_depth)
+}
+
+// pipeline_report describes the pipeline depth achieved: the number of
+// responses read per batch, less than requested when the server closes
+// connections with requests pending.
+type pipeline_report struct {
+	Depth       int     `json:"depth"`
+	Connections int64   `json:"connections"`
+	Batches     int64   `json:"batches"`
+	MeanDepth   float64 `json:"mean_depth"`
+	MaxDepth    int     `json:"max_depth"`
+}
+
+func new_pipeline_report(depth int, p *pipeline_stats) *pipeline_report {
+	r := &pipeline_report{Depth: depth, Connections: p.connections, Batches: p.batches, MaxDepth: p.max_depth}
+	if p.batches > 0 {
+		r.MeanDepth = float64(p.answered) / float64(p.batches)
+	}
+	return r
+}
+
+// print_pipeline writes the pipelining block of the summary.
+func print_pipeline(w io.Writer, r *pipeline_report) {
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(w, "Pipelining: depth %d requested, %.2f achieved on average (max %d), %d batches over %d connections\n",
+		r.Depth, r.MeanDepth, r.MaxDepth, r.Batches, r.Connections)
+}
+
+// pipeline_dialer opens the HTTP/1.1 connections of pipelining mode, with
+// the dial function and TLS configuration of the transport.
+func pipeline_dialer(t *http.Transport, u *neturl.URL) func() (net.Conn, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	var tls_config *tls.Config
+	if u.Scheme == "https" {
+		tls_config = t.TLSClientConfig.Clone()
+		if tls_config.ServerName == "" {
+			tls_config.ServerName = u.Hostname()
+		}
+		tls_config.NextProtos = []string{"http/1.1"}
+	}
+	return func() (net.Conn, error) {
+		conn, err := dial(context.Background(), "tcp", addr)
+		if err != nil || tls_config == nil {
+			return conn, err
+		}
+		tc := tls.Client(conn, tls_config)
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+}
+
+// send_pipelined is the worker of pipelining mode: it writes batches of
+// depth requests on its connection before reading their responses, and
+// sends iter requests (or until stopped if iter < 0). Requests left
+// unanswered when the server closes the connection are sent again on a
+// new one.
+func send_pipelined(j *job, id int, iter int, delay time.Duration, depth int, st *stats, ps *pipeline_stats) {
+	u, err := neturl.Parse(j.url)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	dial := pipeline_dialer(j.client.Transport.(*http.Transport), u)
+	new_request := func() *http.Request {
+		req, _ := http.NewRequest(j.method, j.url, nil)
+		for _, hf := range j.hdr {
+			req.Header.Add(hf.name, hf.value)
+		}
+		if j.template {
+			if body, err := j.expand_request(req, nil, nil, nil); err == nil && body != "" {
+				j.set_body(req, body)
+			}
+		} else if j.form != nil {
+			body, content_type := j.form.build()
+			j.set_body(req, body)
+			req.Header.Set("Content-Type", content_type)
+		} else if 0 < len(j.body) {
+			j.set_body(req, j.body)
+		}
+		if j.user != "" {
+			req.SetBasicAuth(j.user, j.pass)
+		}
+		if j.bearer != nil {
+			req.Header.Set("Authorization", "Bearer "+j.bearer.get())
+		}
+		if j.jwt != nil {
+			if token, err := j.jwt.token(id, nil); err == nil {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		return req
+	}
+
+	ready_ch <- true
+	<-start_ch
+	if !pause(delay) {
+		done_ch <- true
+		return
+	}
+
+	var conn net.Conn
+	var br *bufio.Reader
+	var bw *bufio.Writer
+	fail := func(err error) {
+		kind := classify_error(err)
+		log_error(kind, err)
+		st.record_error(kind)
+		if live != nil {
+			live.record_error(kind)
+		}
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	for i, b := 0, 0; (iter < 0 || i < iter) && !stopped(); b++ {
+		if b > 0 && j.think > 0 && !pause(j.think_time()) {
+			break
+		}
+		if j.gate != nil && !j.gate.wait(id) {
+			break
+		}
+		if conn == nil {
+			if conn, err = dial(); err != nil {
+				if j.spend(1, false) == 0 {
+					break
+				}
+				fail(err)
+				i++
+				continue
+			}
+			ps.connections++
+			br, bw = bufio.NewReader(conn), bufio.NewWriter(conn)
+		}
+		n := depth
+		if iter >= 0 {
+			n = min(n, iter-i)
+		}
+		if n = j.spend(n, true); n == 0 {
+			break
+		}
+		if j.client.Timeout > 0 {
+			conn.SetDeadline(time.Now().Add(j.client.Timeout))
+		}
+		reqs := make([]*http.Request, n)
+		sent := time.Now()
+		for k := range reqs {
+			reqs[k] = new_request()
+			if err = reqs[k].Write(bw); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			fail(err)
+			i++
+			if j.budget != nil {
+				j.budget.settle(n, 1)
+			}
+			continue
+		}
+		answered, failed := 0, 0
+		for _, req := range reqs {
+			var resp *http.Response
+			resp, err = http.ReadResponse(br, req)
+			var size int64
+			if err == nil {
+				size, err = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			if err != nil {
+				fail(err)
+				i++
+				failed = 1
+				break
+			}
+			latency := time.Since(sent)
+			st.record_latency(latency)
+			st.record_status(resp.StatusCode)
+			st.record_bytes(size)
+			if live != nil {
+				live.record_response(latency, resp.StatusCode, size)
+			}
+			answered++
+			if j.max_bytes > 0 && j.received.Add(size) >= j.max_bytes {
+				stop_workers()
+			}
+			if resp.Close {
+				conn.Close()
+				conn = nil
+				break
+			}
+		}
+		i += answered
+		// Requests left unanswered by a closed connection are sent again
+		if j.budget != nil {
+			j.budget.settle(n, answered+failed)
+		}
+		ps.batches++
+		ps.answered += int64(answered)
+		ps.max_depth = max(ps.max_depth, answered)
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	done_ch <- true
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// read_plan reads a stage plan from a file written in a subset of TOML,
+// one [[stage]] table per stage:
+//
+//	# Warm up, then load test
+//	[[stage]]
+//	name = "warmup"
+//	duration = "1m"
+//	rate = 50
+//	concurrency = 10
+//
+//	[[stage]]
+//	duration = "5m"
+//	rate = 200
+//	url = "http://127.0.0.1/search"
+//
+// Keys left out keep the value of the previous stage, as with -stages,
+// except cooldown: the idle gap after the stage, -cooldown by default.
+func read_plan(name string) ([]stage, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stages, err := parse_plan(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return stages, nil
+}
+
+func parse_plan(r io.Reader) ([]stage, error) {
+	var stages []stage
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(strip_comment(sc.Text()))
+		if text == "" {
+			continue
+		}
+		if text == "[[stage]]" {
+			stages = append(stages, stage{})
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok || len(stages) == 0 {
+			return nil, fmt.Errorf("line %d: expected [[stage]] or key = value", line)
+		}
+		if err := set_plan_key(&stages[len(stages)-1], strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(stages) == 0 {
+		return nil, errorString("no [[stage]] in plan")
+	}
+	for i, s := range stages {
+		if s.duration <= 0 {
+			return nil, fmt.Errorf("stage %d has no duration", i+1)
+		}
+	}
+	return stages, nil
+}
+
+// strip_comment removes a # comment, unless within a quoted string.
+func strip_comment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// set_plan_key sets a stage field from a key = value line.
+func set_plan_key(s *stage, key, value string) error {
+	unquote := func() (string, error) {
+		v, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a quoted string", key)
+		}
+		return v, nil
+	}
+	var err error
+	switch key {
+	case "name":
+		s.name, err = unquote()
+	case "duration":
+		var v string
+		if v, err = unquote(); err == nil {
+			s.duration, err = time.ParseDuration(v)
+		}
+	case "cooldown":
+		var v string
+		if v, err = unquote(); err == nil {
+			s.cooldown, err = time.ParseDuration(v)
+		}
+	case "rate":
+		s.rate, err = strconv.ParseFloat(value, 64)
+		if err == nil && s.rate <= 0 {
+			err = errorString("rate must be positive")
+		}
+	case "concurrency":
+		s.conc, err = strconv.Atoi(value)
+		if err == nil && s.conc <= 0 {
+			err = errorString("concurrency must be positive")
+		}
+	case "url":
+		var v string
+		if v, err = unquote(); err == nil {
+			s.url, err = url.Parse(v)
+		}
+	default:
+		err = fmt.Errorf("unknown key %q", key)
+	}
+	return err
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// postman_item is a request, or a folder of items, of a Postman collection
+// (format v2.1).
+type postman_item struct {
+	Name    string          `json:"name"`
+	Items   []postman_item  `json:"item"`
+	Auth    *postman_auth   `json:"auth"`    // of a folder
+	Request json.RawMessage `json:"request"` // object, or URL string
+}
+
+type postman_request struct {
+	Method string          `json:"method"`
+	URL    json.RawMessage `json:"url"` // object with raw, or string
+	Header []struct {
+		Key      string `json:"key"`
+		Value    string `json:"value"`
+		Disabled bool   `json:"disabled"`
+	} `json:"header"`
+	Body *struct {
+		Mode       string             `json:"mode"`
+		Raw        string             `json:"raw"`
+		URLEncoded []postman_variable `json:"urlencoded"`
+		Options    struct {
+			Raw struct {
+				Language string `json:"language"`
+			} `json:"raw"`
+		} `json:"options"`
+	} `json:"body"`
+	Auth *postman_auth `json:"auth"`
+}
+
+type postman_auth struct {
+	Type   string             `json:"type"`
+	Bearer []postman_variable `json:"bearer"`
+	Basic  []postman_variable `json:"basic"`
+	APIKey []postman_variable `json:"apikey"` // key and value, in a header or the query
+}
+
+type postman_variable struct {
+	Key      string `json:"key"`
+	Value    any    `json:"value"`
+	Disabled bool   `json:"disabled"`
+	Enabled  *bool  `json:"enabled"` // in environments
+}
+
+func (v postman_variable) String() string {
+	if s, ok := v.Value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v.Value)
+}
+
+type postman_collection struct {
+	Items    []postman_item     `json:"item"`
+	Variable []postman_variable `json:"variable"`
+	Auth     *postman_auth      `json:"auth"`
+}
+
+// Postman variables, and its dynamic variables with hammer placeholders
+var (
+	postman_var     = regexp.MustCompile(`{{\s*([^{}\s]+)\s*}}`)
+	postman_dynamic = map[string]string{
+		"$guid": "{{uuid}}", "$randomUUID": "{{uuid}}", "$timestamp": "{{timestamp}}",
+		"$randomInt": "{{randint 0 1000}}",
+	}
+)
+
+// postman_vars substitutes the variables of a collection and of its
+// environment. It records whether dynamic variables were substituted, so
+// that placeholders are expanded.
+type postman_vars struct {
+	values  map[string]string
+	dynamic bool
+}
+
+func (pv *postman_vars) expand(s string) string {
+	return postman_var.ReplaceAllStringFunc(s, func(m string) string {
+		name := postman_var.FindStringSubmatch(m)[1]
+		if v, ok := pv.values[name]; ok {
+			return v
+		}
+		if v, ok := postman_dynamic[name]; ok {
+			pv.dynamic = true
+			return v
+		}
+		return m
+	})
+}
+
+// read_postman returns the weighted scenario of the requests of a Postman
+// collection, and whether it uses dynamic variables. Requests are selected
+// by a comma-separated list of NAME=WEIGHT (or NAME, of weight 1), NAME
+// being the name of the request or its FOLDER/NAME path; all are if the
+// list is empty. The variables of the environment file, if any, override
+// those of the collection.
+func read_postman(file, env_file, selection string) (*scenario, bool, error) {
+	var c postman_collection
+	if err := read_json(file, &c); err != nil {
+		return nil, false, err
+	}
+	pv := &postman_vars{values: make(map[string]string)}
+	for _, v := range c.Variable {
+		if !v.Disabled {
+			pv.values[v.Key] = v.String()
+		}
+	}
+	if env_file != "" {
+		var env struct {
+			Values []postman_variable `json:"values"`
+		}
+		if err := read_json(env_file, &env); err != nil {
+			return nil, false, err
+		}
+		for _, v := range env.Values {
+			if v.Enabled == nil || *v.Enabled {
+				pv.values[v.Key] = v.String()
+			}
+		}
+	}
+	weights, order, err := parse_weights(selection)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s := &scenario{}
+	selected := make(map[string]bool)
+	var walk func(items []postman_item, folder string, auth *postman_auth) error
+	walk = func(items []postman_item, folder string, auth *postman_auth) error {
+		for _, it := range items {
+			path := it.Name
+			if folder != "" {
+				path = folder + "/" + it.Name
+			}
+			if it.Items != nil {
+				// The auth of a folder overrides that of its parent
+				folder_auth := auth
+				if it.Auth != nil && it.Auth.Type != "inherit" {
+					folder_auth = it.Auth
+				}
+				if err := walk(it.Items, path, folder_auth); err != nil {
+					return err
+				}
+				continue
+			}
+			weight := 1.0
+			if len(weights) > 0 {
+				name := path
+				w, ok := weights[name]
+				if !ok {
+					name = it.Name
+					w, ok = weights[name]
+				}
+				if !ok {
+					continue
+				}
+				weight, selected[name] = w, true
+			}
+			e, err := postman_endpoint(it, auth, pv)
+			if err != nil {
+				return fmt.Errorf("%s: request %q: %v", file, path, err)
+			}
+			if e != nil {
+				e.weight = weight
+				s.endpoints = append(s.endpoints, *e)
+			}
+		}
+		return nil
+	}
+	if err := walk(c.Items, "", c.Auth); err != nil {
+		return nil, false, err
+	}
+	for _, name := range order {
+		if !selected[name] {
+			return nil, false, fmt.Errorf("%s: no request named %q", file, name)
+		}
+	}
+	if len(s.endpoints) == 0 {
+		return nil, false, fmt.Errorf("%s: no request found", file)
+	}
+	s.prepare()
+	return s, pv.dynamic, nil
+}
+
+// postman_endpoint returns the endpoint of a collection request, or nil if
+// its body cannot be sent (form-data and file bodies).
+func postman_endpoint(it postman_item, auth *postman_auth, pv *postman_vars) (*endpoint, error) {
+	var r postman_request
+	if len(it.Request) > 0 && it.Request[0] == '"' {
+		r.URL = it.Request
+	} else if err := json.Unmarshal(it.Request, &r); err != nil {
+		return nil, err
+	}
+	var raw string
+	if err := json.Unmarshal(r.URL, &raw); err != nil {
+		var u struct {
+			Raw string `json:"raw"`
+		}
+		if err := json.Unmarshal(r.URL, &u); err != nil {
+			return nil, err
+		}
+		raw = u.Raw
+	}
+	raw = pv.expand(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if r.Method == "" {
+		r.Method = "GET"
+	}
+	e := &endpoint{name: it.Name, method: r.Method, url: u, raw: raw}
+	content_type := ""
+	for _, h := range r.Header {
+		if !h.Disabled {
+			e.hdr = append(e.hdr, hfield{h.Key, pv.expand(h.Value)})
+			if strings.EqualFold(h.Key, "Content-Type") {
+				content_type = h.Value
+			}
+		}
+	}
+	if r.Body != nil {
+		switch r.Body.Mode {
+		case "raw":
+			e.body = pv.expand(r.Body.Raw)
+			if content_type == "" && r.Body.Options.Raw.Language == "json" {
+				e.hdr = append(e.hdr, hfield{"Content-Type", "application/json"})
+			}
+		case "urlencoded":
+			form := neturl.Values{}
+			for _, v := range r.Body.URLEncoded {
+				if !v.Disabled {
+					form.Add(pv.expand(v.Key), pv.expand(v.String()))
+				}
+			}
+			e.body = form.Encode()
+			if content_type == "" {
+				e.hdr = append(e.hdr, hfield{"Content-Type", "application/x-www-form-urlencoded"})
+			}
+		case "", "none":
+		default:
+			log.Printf("Left out Postman request %q: %s bodies are not supported", it.Name, r.Body.Mode)
+			return nil, nil
+		}
+	}
+	if r.Auth != nil && r.Auth.Type != "inherit" {
+		auth = r.Auth
+	}
+	if auth != nil {
+		param := func(list []postman_variable, key string) string {
+			for _, v := range list {
+				if v.Key == key {
+					return pv.expand(v.String())
+				}
+			}
+			return ""
+		}
+		switch auth.Type {
+		case "bearer":
+			e.hdr = append(e.hdr, hfield{"Authorization", "Bearer " + param(auth.Bearer, "token")})
+		case "basic":
+			creds := param(auth.Basic, "username") + ":" + param(auth.Basic, "password")
+			e.hdr = append(e.hdr, hfield{"Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))})
+		case "apikey":
+			key, value := param(auth.APIKey, "key"), param(auth.APIKey, "value")
+			if param(auth.APIKey, "in") != "query" {
+				e.hdr = append(e.hdr, hfield{key, value})
+				break
+			}
+			sep := "?"
+			if strings.Contains(e.raw, "?") {
+				sep = "&"
+			}
+			e.raw += sep + neturl.QueryEscape(key) + "=" + neturl.QueryEscape(value)
+			if e.url, err = neturl.Parse(e.raw); err != nil {
+				return nil, err
+			}
+		case "noauth":
+		default:
+			log.Printf("Postman request %q sent without its %s authentication, which is not supported", it.Name, auth.Type)
+		}
+	}
+	return e, nil
+}
+
+// read_json decodes a JSON file.
+func read_json(file string, v any) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progress periodically prints a one line status of the run.
+type progress struct {
+	w        io.Writer
+	every    time.Duration
+	total    int64
+	requests int64 // since the last printed line
+	errors   int64 // since the last printed line
+	since    time.Duration
+}
+
+func new_progress(w io.Writer, every time.Duration) *progress {
+	return &progress{w: w, every: every}
+}
+
+// sample is the sample_hook accumulating intervals until a line is due.
+func (p *progress) sample(elapsed, interval time.Duration, s *stats) {
+	p.requests += s.requests
+	p.errors += s.errors
+	p.total += s.requests + s.errors
+	// Allow for ticker jitter: half an interval early is on time
+	if elapsed-p.since+interval/2 < p.every {
+		return
+	}
+	var rps, error_rate float64
+	if d := (elapsed - p.since).Seconds(); d > 0 {
+		rps = float64(p.requests) / d
+	}
+	if n := p.requests + p.errors; n > 0 {
+		error_rate = float64(p.errors) * 100 / float64(n)
+	}
+	fmt.Fprintf(p.w, "[%6.1fs] %d requests, %.2f rps, %.2f%% errors\n", elapsed.Seconds(), p.total, rps, error_rate)
+	p.requests, p.errors, p.since = 0, 0, elapsed
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	neturl "net/url"
+	"time"
+)
+
+// raw_target describes a raw TCP or TLS exchange: the payload sent, then
+// the reply awaited, if any.
+type raw_target struct {
+	addr         string
+	tls          *tls.Config // nil for plain TCP
+	payload      []byte
+	expect_bytes int    // reply length, 0 unless fixed
+	delim        []byte // reply terminator, nil unless set
+	timeout      time.Duration
+	handshake    bool       // connect and handshake only, then close
+	dns          *dns_query // nil unless sending DNS queries over UDP
+}
+
+// new_raw_target returns the target of a tcp://host:port or tls://host:port
+// URL, or nil for other URLs.
+func new_raw_target(u string, tls_config *tls.Config) (*raw_target, error) {
+	pu, err := neturl.Parse(u)
+	if err != nil || (pu.Scheme != "tcp" && pu.Scheme != "tls") {
+		return nil, err
+	}
+	if pu.Port() == "" {
+		return nil, fmt.Errorf("URL %q has no port", u)
+	}
+	rt := &raw_target{addr: pu.Host}
+	if pu.Scheme == "tls" {
+		rt.tls = tls_config.Clone()
+		if rt.tls.ServerName == "" {
+			rt.tls.ServerName = pu.Hostname()
+		}
+	}
+	return rt, nil
+}
+
+// new_handshake_target returns the target of handshake-only mode, for an
+// https:// or tls:// URL. Each connection performs a full handshake,
+// unless -tls-sessions lets it resume an earlier session.
+func new_handshake_target(u string, tls_config *tls.Config) (*raw_target, error) {
+	pu, err := neturl.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if pu.Scheme != "https" && pu.Scheme != "tls" {
+		return nil, fmt.Errorf("-handshake needs an https:// or tls:// URL, not %q", u)
+	}
+	rt := &raw_target{addr: pu.Host, handshake: true}
+	if pu.Port() == "" {
+		if pu.Scheme == "tls" {
+			return nil, fmt.Errorf("URL %q has no port", u)
+		}
+		rt.addr = net.JoinHostPort(pu.Hostname(), "443")
+	}
+	rt.tls = tls_config.Clone()
+	if rt.tls.ServerName == "" {
+		rt.tls.ServerName = pu.Hostname()
+	}
+	return rt, nil
+}
+
+func (rt *raw_target) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: rt.timeout}
+	if rt.dns != nil {
+		return d.Dial("udp", rt.addr)
+	}
+	if rt.tls != nil {
+		return tls.DialWithDialer(d, "tcp", rt.addr, rt.tls)
+	}
+	return d.Dial("tcp", rt.addr)
+}
+
+// exchange sends the payload and reads the reply, returning its size and
+// the arrival time of its first byte (zero without an expected reply).
+func (rt *raw_target) exchange(conn net.Conn, br *bufio.Reader) (int64, time.Time, error) {
+	if rt.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(rt.timeout))
+	}
+	if rt.dns != nil {
+		n, err := rt.dns.exchange(conn)
+		return n, time.Time{}, err
+	}
+	if _, err := conn.Write(rt.payload); err != nil {
+		return 0, time.Time{}, err
+	}
+	if rt.delim == nil && rt.expect_bytes <= 0 {
+		return 0, time.Time{}, nil
+	}
+	if _, err := br.Peek(1); err != nil {
+		return 0, time.Time{}, err
+	}
+	first_byte := time.Now()
+	n, err := rt.read_reply(br)
+	return n, first_byte, err
+}
+
+func (rt *raw_target) read_reply(br *bufio.Reader) (int64, error) {
+	if rt.delim == nil {
+		return io.CopyN(io.Discard, br, int64(rt.expect_bytes))
+	}
+	var reply []byte
+	for !bytes.HasSuffix(reply, rt.delim) {
+		b, err := br.ReadByte()
+		if err != nil {
+			return int64(len(reply)), err
+		}
+		reply = append(reply, b)
+	}
+	return int64(len(reply)), nil
+}
+
+// await_ticket gives the server up to the handshake duration d to send a
+// TLS 1.3 session ticket, only received after the handshake, when sessions
+// are cached.
+func (rt *raw_target) await_ticket(conn net.Conn, d time.Duration) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok || rt.tls.ClientSessionCache == nil || tc.ConnectionState().Version != tls.VersionTLS13 {
+		return
+	}
+	tc.SetReadDeadline(time.Now().Add(d))
+	tc.Read(make([]byte, 1))
+}
+
+// send_payloads is the worker of raw TCP/TLS mode: it keeps a connection
+// open, reconnecting after errors, and measures the round trip of each
+// payload and reply. In handshake-only mode, each request is a new
+// connection, closed once the TLS handshake completes.
+func send_payloads(j *job, id int, iter int, delay time.Duration, st *stats) {
+	rt := j.raw
+	ready_ch <- true
+	<-start_ch
+	if !pause(delay) {
+		done_ch <- true
+		return
+	}
+
+	pc := j.pacer
+	if j.conn_rate > 0 {
+		pc = new_pacer(j.conn_rate, j.poisson)
+		pc.start(time.Now().Add(time.Duration(rand.Int63n(int64(pc.interval) + 1))))
+	}
+
+	var conn net.Conn
+	var br *bufio.Reader
+	for i := 0; (iter < 0 || i < iter) && !stopped(); i++ {
+		if i > 0 && j.think > 0 && !pause(j.think_time()) {
+			break
+		}
+		if j.gate != nil && !j.gate.wait(id) {
+			break
+		}
+		var scheduled time.Time
+		if pc != nil {
+			scheduled = pc.wait()
+			if stopped() {
+				break
+			}
+			if j.gate != nil && j.gate.closed(id) {
+				i--
+				continue
+			}
+		}
+		if j.spend(1, false) == 0 {
+			break
+		}
+		if j.inflight != nil {
+			select {
+			case j.inflight <- true:
+			case <-stop_ch:
+			}
+			if stopped() {
+				break
+			}
+		}
+		sent := time.Now()
+		var err error
+		if conn == nil {
+			if conn, err = rt.dial(); err == nil {
+				br = bufio.NewReader(conn)
+				if tc, ok := conn.(*tls.Conn); ok && j.tls != nil {
+					j.tls.record(tc.ConnectionState())
+				}
+			}
+		}
+		var size int64
+		var first_byte time.Time
+		var done time.Time
+		if err == nil && rt.handshake {
+			done = time.Now()
+			rt.await_ticket(conn, done.Sub(sent))
+			conn.Close()
+			conn = nil
+		} else if err == nil {
+			size, first_byte, err = rt.exchange(conn, br)
+		}
+		if j.inflight != nil {
+			<-j.inflight
+		}
+		if err != nil {
+			kind := classify_error(err)
+			log_error(kind, err)
+			st.record_error(kind)
+			if live != nil {
+				live.record_error(kind)
+			}
+			// A DNS error response leaves the socket usable
+			var de *dns_error
+			if conn != nil && !errors.As(err, &de) {
+				conn.Close()
+				conn = nil
+			}
+			continue
+		}
+		if j.corrected {
+			sent = scheduled
+		}
+		latency := time.Since(sent)
+		if !done.IsZero() {
+			latency = done.Sub(sent)
+		}
+		st.record_latency(latency)
+		if !first_byte.IsZero() {
+			st.record_ttfb(first_byte.Sub(sent))
+		}
+		st.record_bytes(size)
+		if live != nil {
+			live.record_response(latency, 0, size)
+		}
+		if j.max_bytes > 0 && j.received.Add(size) >= j.max_bytes {
+			stop_workers()
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	done_ch <- true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// max_redirects is the -follow-redirects flag: the number of redirects
+// followed per request, 10 if set without a value.
+type max_redirects int
+
+func (m *max_redirects) String() string {
+	return strconv.Itoa(int(*m))
+}
+
+func (m *max_redirects) Set(value string) error {
+	switch value {
+	case "true":
+		*m = 10
+		return nil
+	case "false":
+		*m = 0
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return errorString("Redirect count must be a positive integer")
+	}
+	*m = max_redirects(n)
+	return nil
+}
+
+func (m *max_redirects) IsBoolFlag() bool { return true }
+
+// check_redirect returns the CheckRedirect function of the client: once n
+// redirects were followed, the last 3xx response is returned as is.
+func check_redirect(n int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > n {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+// redirects returns the number of redirects followed to get resp.
+func redirects(resp *http.Response) int64 {
+	var n int64
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		n++
+	}
+	return n
+}
+
+// print_redirects writes the redirect line of the summary.
+func print_redirects(w io.Writer, n, requests int64) {
+	if n == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Redirects followed: %d, %.2f per request\n", n, float64(n)/float64(max(requests, 1)))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// Access log lines: Common or Combined Log Format, or just a method and a
+// path
+var (
+	clf_line    = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)[^"]*"`)
+	simple_line = regexp.MustCompile(`^([A-Z]+) (/\S*)`)
+)
+
+const clf_time = "02/Jan/2006:15:04:05 -0700"
+
+// replay_entry is a request of an access log.
+type replay_entry struct {
+	method string
+	url    *neturl.URL
+	offset time.Duration // from the first request of the log
+}
+
+// replay hands out the requests of an access log to the workers, in order,
+// each due at its original time in the log divided by speed (as soon as
+// possible if speed is 0). The log is replayed once, or over and over if
+// loop is set.
+type replay struct {
+	entries []replay_entry
+	next    atomic.Int64
+	speed   float64
+	loop    bool
+	begin   time.Time
+}
+
+// read_replay reads an access log, the requests being sent to the scheme
+// and host of base. Lines without a timestamp get that of the previous one.
+func read_replay(file string, base *neturl.URL) (*replay, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &replay{}
+	var first, last time.Time
+	skipped := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var method, path string
+		when := last
+		if m := clf_line.FindStringSubmatch(sc.Text()); m != nil {
+			method, path = m[2], m[3]
+			if t, err := time.Parse(clf_time, m[1]); err == nil {
+				when = t
+			}
+		} else if m := simple_line.FindStringSubmatch(sc.Text()); m != nil {
+			method, path = m[1], m[2]
+		}
+		ref, err := neturl.Parse(path)
+		if method == "" || err != nil {
+			skipped++
+			continue
+		}
+		var offset time.Duration
+		if !when.IsZero() {
+			if first.IsZero() {
+				first = when
+			}
+			offset, last = when.Sub(first), when
+		}
+		r.entries = append(r.entries, replay_entry{method, base.ResolveReference(ref), offset})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(r.entries) == 0 {
+		return nil, fmt.Errorf("%s: no request found", file)
+	}
+	if skipped > 0 {
+		log.Printf("%s: skipped %d lines without a request", file, skipped)
+	}
+	return r, nil
+}
+
+// start sets the beginning of the replay.
+func (r *replay) start(begin time.Time) {
+	r.begin = begin
+}
+
+// take returns the next request of the log once it is due, or false once
+// all were taken or if the workers were stopped meanwhile.
+func (r *replay) take() (*replay_entry, bool) {
+	i := r.next.Add(1) - 1
+	n := int64(len(r.entries))
+	if i >= n && !r.loop {
+		return nil, false
+	}
+	e := &r.entries[i%n]
+	// Each pass over the log lasts as long as the log
+	offset := e.offset + time.Duration(i/n)*r.entries[n-1].offset
+	if r.speed > 0 && !pause(time.Until(r.begin.Add(time.Duration(float64(offset)/r.speed)))) {
+		return nil, false
+	}
+	return e, true
+}
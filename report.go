@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// report_config is the part of the run configuration included in results.
+type report_config struct {
+	URL         string   `json:"url"`
+	Method      string   `json:"method"`
+	Model       string   `json:"model"` // closed or open loop
+	Concurrency int      `json:"concurrency"`
+	MaxInflight int      `json:"max_inflight,omitempty"`
+	Requests    int      `json:"requests,omitempty"`
+	Duration    float64  `json:"duration_seconds,omitempty"`
+	MaxBytes    int64    `json:"max_bytes,omitempty"`
+	Rate        float64  `json:"rate,omitempty"`
+	RatePerConn float64  `json:"rate_per_conn,omitempty"`
+	Arrival     string   `json:"arrival,omitempty"`
+	KeepAlive   bool     `json:"keep_alive"`
+	HTTP2       bool     `json:"http2,omitempty"`
+	Compress    bool     `json:"compress"`
+	Encodings   []string `json:"encodings,omitempty"`
+	Corrected   bool     `json:"co_corrected,omitempty"`
+	Think       float64  `json:"think_ms,omitempty"`
+	Jitter      float64  `json:"think_jitter_ms,omitempty"`
+	Handshake   bool     `json:"handshake_only,omitempty"`
+}
+
+type percentile_report struct {
+	Percentile float64 `json:"percentile"`
+	Value      float64 `json:"value"`
+}
+
+// latency_report holds latency figures in milliseconds.
+type latency_report struct {
+	Min         float64             `json:"min"`
+	Mean        float64             `json:"mean"`
+	StdDev      float64             `json:"stddev"`
+	Max         float64             `json:"max"`
+	Percentiles []percentile_report `json:"percentiles"`
+}
+
+// window_report holds the extreme throughputs over a sliding window.
+type window_report struct {
+	Size float64 `json:"size_seconds"`
+	Peak float64 `json:"peak_tps"`
+	Min  float64 `json:"min_tps"`
+}
+
+// worker_report holds the figures of one worker, latencies in milliseconds.
+type worker_report struct {
+	Worker   int     `json:"worker"`
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+	Mean     float64 `json:"mean"`
+	P50      float64 `json:"p50"`
+	P99      float64 `json:"p99"`
+	Max      float64 `json:"max"`
+}
+
+// report_histograms holds the full latency distributions of a run, so that
+// the results of several runs can be merged.
+type report_histograms struct {
+	Latency *histogram_data `json:"latency_us"`
+	TTFB    *histogram_data `json:"ttfb_us"`
+}
+
+// report holds the results of a run, in a form suitable for JSON encoding.
+type report struct {
+	Config      report_config             `json:"config"`
+	Start       time.Time                 `json:"start"`
+	Interrupted bool                      `json:"interrupted,omitempty"`
+	Aborted     string                    `json:"aborted,omitempty"`
+	EndedBy     string                    `json:"ended_by,omitempty"` // limit which ended the run: requests, duration or bytes
+	Duration    float64                   `json:"duration_seconds"`
+	Requests    int64                     `json:"requests"`
+	Errors      int64                     `json:"errors"`
+	ErrorKinds  map[string]int64          `json:"error_kinds,omitempty"`
+	Throughput  float64                   `json:"throughput_tps"`
+	Window      *window_report            `json:"window,omitempty"`
+	Bytes       int64                     `json:"bytes"`
+	Redirects   int64                     `json:"redirects,omitempty"`
+	MeanSize    float64                   `json:"mean_response_size"`
+	Bandwidth   float64                   `json:"bandwidth_mbps"` // megabytes per second
+	Latency     latency_report            `json:"latency_ms"`
+	TTFB        latency_report            `json:"ttfb_ms"`
+	Phases      map[string]latency_report `json:"phases_ms,omitempty"`
+	Continue    *latency_report           `json:"continue_ms,omitempty"`
+	Compression *compression_report       `json:"compression,omitempty"`
+	Churn       *churn_report             `json:"churn,omitempty"`
+	SSE         *sse_report               `json:"sse,omitempty"`
+	Pipeline    *pipeline_report          `json:"pipeline,omitempty"`
+	Affinity    *affinity_report          `json:"affinity,omitempty"`
+	HTTP2       *http2_report             `json:"http2,omitempty"`
+	TLS         *tls_report               `json:"tls,omitempty"`
+	StatusCodes map[int]int64             `json:"status_codes"`
+	GRPCStatus  map[string]int64          `json:"grpc_status,omitempty"`
+	Workers     []worker_report           `json:"workers,omitempty"`
+	Agents      []agent_report            `json:"agents,omitempty"`
+	Stages      []stage_report            `json:"stages,omitempty"`
+	Endpoints   []endpoint_report         `json:"endpoints,omitempty"`
+	Knee        *knee_report              `json:"knee,omitempty"`
+	Capacity    *capacity_report          `json:"capacity,omitempty"`
+	Throttle    *throttle_report          `json:"throttle,omitempty"`
+	Assertions  []assertion               `json:"assertions,omitempty"`
+	Histograms  *report_histograms        `json:"histograms,omitempty"`
+}
+
+// Percentiles reported in summaries
+var report_percentiles = []float64{50, 75, 90, 99, 99.9}
+
+func new_latency_report(h *histogram) latency_report {
+	l := latency_report{
+		Min:    usec_to_ms(float64(h.minimum())),
+		Mean:   usec_to_ms(h.mean()),
+		StdDev: usec_to_ms(h.std_dev()),
+		Max:    usec_to_ms(float64(h.maximum())),
+	}
+	for _, p := range report_percentiles {
+		l.Percentiles = append(l.Percentiles, percentile_report{p, usec_to_ms(float64(h.value_at_percentile(p)))})
+	}
+	return l
+}
+
+// new_report builds the results of a run from the merged worker statistics.
+func new_report(cfg report_config, st *stats, begin, end time.Time) *report {
+	elapsed := end.Sub(begin).Seconds()
+	r := &report{
+		Config:      cfg,
+		Start:       begin,
+		Duration:    elapsed,
+		Requests:    st.requests,
+		Errors:      st.errors,
+		ErrorKinds:  st.failures,
+		Latency:     new_latency_report(st.latency),
+		TTFB:        new_latency_report(st.ttfb),
+		StatusCodes: st.codes,
+		GRPCStatus:  grpc_status_counts(st.grpc),
+		Bytes:       st.bytes,
+		Redirects:   st.redirects,
+	}
+	if st.cont != nil {
+		lr := new_latency_report(st.cont)
+		r.Continue = &lr
+	}
+	for phase, h := range st.phases {
+		if h != nil {
+			if r.Phases == nil {
+				r.Phases = make(map[string]latency_report)
+			}
+			r.Phases[phase_names[phase]] = new_latency_report(h)
+		}
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(st.requests) / elapsed
+		r.Bandwidth = float64(st.bytes) / 1e6 / elapsed
+	}
+	if st.requests > 0 {
+		r.MeanSize = float64(st.bytes) / float64(st.requests)
+	}
+	return r
+}
+
+// add_window adds the sliding window throughputs to the results, if a
+// whole window was observed.
+func (r *report) add_window(w *window) {
+	if w.full {
+		r.Window = &window_report{w.size.Seconds(), w.peak, w.min}
+	}
+}
+
+// add_workers adds the per-worker breakdown to the results.
+func (r *report) add_workers(workers []*stats) {
+	for i, st := range workers {
+		r.Workers = append(r.Workers, worker_report{
+			Worker:   i,
+			Requests: st.requests,
+			Errors:   st.errors,
+			Mean:     usec_to_ms(st.latency.mean()),
+			P50:      usec_to_ms(float64(st.latency.value_at_percentile(50))),
+			P99:      usec_to_ms(float64(st.latency.value_at_percentile(99))),
+			Max:      usec_to_ms(float64(st.latency.maximum())),
+		})
+	}
+}
+
+// write_json writes the results as an indented JSON document.
+func write_json(w io.Writer, r *report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// write_text writes the human readable summary of the results.
+func write_text(w io.Writer, r *report, st *stats) {
+	if r.Interrupted {
+		fmt.Fprintln(w, "Run interrupted, partial results:")
+	}
+	if r.Aborted != "" {
+		fmt.Fprintf(w, "Run aborted: %s\n", r.Aborted)
+	}
+	if r.EndedBy == "bytes" {
+		fmt.Fprintf(w, "Run ended after receiving %d bytes (-max-bytes)\n", r.Config.MaxBytes)
+	} else if r.Config.Requests > 0 && r.Config.Duration > 0 {
+		switch r.EndedBy {
+		case "requests":
+			fmt.Fprintf(w, "Run ended after %d requests, before the %gs duration limit\n", r.Config.Requests, r.Config.Duration)
+		case "duration":
+			fmt.Fprintf(w, "Run ended by the %gs duration limit, before %d requests\n", r.Config.Duration, r.Config.Requests)
+		}
+	}
+	if r.Config.Handshake {
+		fmt.Fprintf(w, "%d TLS handshakes completed in %.2f seconds - %.2f handshakes/s\n", r.Requests, r.Duration, r.Throughput)
+	} else {
+		fmt.Fprintf(w, "%d requests completed in %.2f seconds - average throughput %.2f tps\n", r.Requests, r.Duration, r.Throughput)
+	}
+	switch {
+	case r.Config.Model == "closed":
+		fmt.Fprintf(w, "Closed loop: each of %d connections sends a request when the previous one completes\n", r.Config.Concurrency)
+	case r.Config.RatePerConn > 0:
+		fmt.Fprintf(w, "Target rate %.2f tps per connection, %.2f tps overall (open loop, %s arrivals)\n",
+			r.Config.RatePerConn, r.Config.RatePerConn*float64(r.Config.Concurrency), r.Config.Arrival)
+	case r.Config.Rate > 0:
+		fmt.Fprintf(w, "Target rate %.2f tps (open loop, %s arrivals)\n", r.Config.Rate, r.Config.Arrival)
+	default:
+		fmt.Fprintf(w, "Variable rate (open loop, %s arrivals)\n", r.Config.Arrival)
+	}
+	if r.Config.Corrected {
+		fmt.Fprintln(w, "Latency measured from scheduled send times (coordinated omission corrected)")
+	}
+	if r.Config.MaxInflight > 0 {
+		fmt.Fprintf(w, "%d connections, at most %d requests in flight\n", r.Config.Concurrency, r.Config.MaxInflight)
+	}
+	if r.Config.Think > 0 {
+		fmt.Fprintf(w, "Think time %g ms (+/- %g ms) between requests of each connection\n", r.Config.Think, r.Config.Jitter)
+	}
+	if r.Window != nil {
+		fmt.Fprintf(w, "Throughput over %gs windows: peak %.2f tps, min %.2f tps\n", r.Window.Size, r.Window.Peak, r.Window.Min)
+	}
+	if !r.Config.Handshake {
+		fmt.Fprintf(w, "%d bytes received - mean response size %.0f bytes, %.2f MB/s\n", r.Bytes, r.MeanSize, r.Bandwidth)
+	}
+	if r.Config.Handshake {
+		print_latency(w, "Connect and handshake latency", st.latency)
+	} else {
+		print_latency(w, "Latency", st.latency)
+	}
+	print_distribution(w, st.latency)
+	if st.ttfb.count() > 0 {
+		print_latency(w, "Time to first byte", st.ttfb)
+	}
+	for phase, h := range st.phases {
+		if h != nil {
+			print_latency(w, fmt.Sprintf("%s [%d samples]", phase_names[phase], h.count()), h)
+		}
+	}
+	if st.cont != nil {
+		print_latency(w, fmt.Sprintf("100 Continue wait [%d of %d requests]", st.cont.count(), st.requests), st.cont)
+	}
+	print_churn(w, r.Churn)
+	print_sse(w, r.SSE)
+	print_pipeline(w, r.Pipeline)
+	print_http2(w, r.HTTP2)
+	print_tls(w, r.TLS)
+	print_status_codes(w, st.codes)
+	print_compression(w, r.Compression, st.requests)
+	print_redirects(w, st.redirects, st.requests)
+	print_affinity(w, r.Affinity)
+	print_grpc_status(w, st.grpc)
+	print_errors(w, st.errors, st.failures)
+	print_endpoints(w, r.Endpoints)
+	if len(r.Workers) != 0 {
+		fmt.Fprintf(w, "Workers:\n  %6s %10s %8s %10s %10s %10s %10s\n", "worker", "requests", "errors", "mean ms", "p50 ms", "p99 ms", "max ms")
+		for _, wr := range r.Workers {
+			fmt.Fprintf(w, "  %6d %10d %8d %10.3f %10.3f %10.3f %10.3f\n", wr.Worker, wr.Requests, wr.Errors, wr.Mean, wr.P50, wr.P99, wr.Max)
+		}
+	}
+	print_agents(w, r.Agents)
+	print_stages(w, r.Stages)
+	print_knee(w, r.Knee)
+	print_capacity(w, r.Capacity)
+	print_throttle(w, r.Throttle)
+	print_assertions(w, r.Assertions)
+}
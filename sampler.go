@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// sample_hook is called with the statistics of all workers over the last
+// interval. elapsed is the time since the beginning of the run at the end
+// of the interval.
+type sample_hook func(elapsed, interval time.Duration, s *stats)
+
+// sampler collects the statistics of all workers over fixed intervals, for
+// reporting while the run is in progress. Unlike per-worker stats it is
+// shared, hence protected by a mutex.
+type sampler struct {
+	mu    sync.Mutex
+	cur   *stats
+	hooks []sample_hook
+	done  chan bool
+	quit  chan bool
+
+	flush_mu sync.Mutex // serializes the ends of intervals
+	begin    time.Time
+	last     time.Time // end of the previous interval
+}
+
+// live is the sampler of the current run, nil if nothing needs live statistics.
+var live *sampler
+
+// add_live_hook registers a hook on the live sampler, creating it if needed.
+func add_live_hook(h sample_hook) {
+	if live == nil {
+		live = new_sampler()
+	}
+	live.add_hook(h)
+}
+
+func new_sampler() *sampler {
+	return &sampler{cur: new_stats(), done: make(chan bool), quit: make(chan bool)}
+}
+
+// add_hook registers a function to call at the end of each interval.
+func (sp *sampler) add_hook(h sample_hook) {
+	sp.hooks = append(sp.hooks, h)
+}
+
+// record_response records one completed request.
+func (sp *sampler) record_response(d time.Duration, code int, size int64) {
+	sp.mu.Lock()
+	sp.cur.record_latency(d)
+	sp.cur.record_status(code)
+	sp.cur.record_bytes(size)
+	sp.mu.Unlock()
+}
+
+// record_error records one failed request.
+func (sp *sampler) record_error(kind string) {
+	sp.mu.Lock()
+	sp.cur.record_error(kind)
+	sp.mu.Unlock()
+}
+
+// swap returns the statistics of the current interval and starts a new one.
+func (sp *sampler) swap() *stats {
+	fresh := new_stats()
+	sp.mu.Lock()
+	s := sp.cur
+	sp.cur = fresh
+	sp.mu.Unlock()
+	return s
+}
+
+// start begins sampling, calling the hooks every interval until stop is
+// called.
+func (sp *sampler) start(begin time.Time, every time.Duration) {
+	sp.begin, sp.last = begin, begin
+	go sp.run(every)
+}
+
+func (sp *sampler) run(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sp.flush()
+		case <-sp.quit:
+			sp.flush()
+			close(sp.done)
+			return
+		}
+	}
+}
+
+// flush ends the current interval now and calls the hooks. It may be called
+// between ticks, e.g. when the load profile changes.
+func (sp *sampler) flush() {
+	sp.flush_mu.Lock()
+	defer sp.flush_mu.Unlock()
+	now := time.Now()
+	s := sp.swap()
+	for _, h := range sp.hooks {
+		h(now.Sub(sp.begin), now.Sub(sp.last), s)
+	}
+	sp.last = now
+}
+
+// stop reports the last, partial interval and stops the sampler.
+func (sp *sampler) stop() {
+	close(sp.quit)
+	<-sp.done
+}
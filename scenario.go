@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpoint is one request of a scenario.
+type endpoint struct {
+	name    string
+	method  string
+	url     *neturl.URL
+	raw     string // url as written, for -template
+	body    string
+	hdr     header
+	weight  float64
+	extract []extractor    // with -flow
+	op      *api_operation // with -openapi, generating the URL and body
+}
+
+// scenario is a weighted mix of requests: each request sent is drawn among
+// its endpoints in proportion to their weights. With -flow, each worker
+// rather sends them in order, over and over. Workers record the statistics
+// of each endpoint separately.
+type scenario struct {
+	endpoints  []endpoint
+	flow       bool
+	cumulative []float64         // of the weights, to draw endpoints
+	stats      []*endpoint_stats // per endpoint, shared by the workers
+}
+
+// endpoint_stats holds the statistics of an endpoint. Unlike those of the
+// workers, they are shared, as one stats per worker and endpoint would take
+// gigabytes with many of both.
+type endpoint_stats struct {
+	mu sync.Mutex
+	st *stats
+}
+
+func (es *endpoint_stats) record_error(kind string) {
+	es.mu.Lock()
+	es.st.record_error(kind)
+	es.mu.Unlock()
+}
+
+func (es *endpoint_stats) record_response(latency time.Duration, code int, size int64) {
+	es.mu.Lock()
+	es.st.record_latency(latency)
+	es.st.record_status(code)
+	es.st.record_bytes(size)
+	es.mu.Unlock()
+}
+
+// read_scenario reads a scenario from a file written in the TOML subset of
+// -plan, one [[request]] table per endpoint:
+//
+//	[[request]]
+//	name = "search"
+//	weight = 80
+//	url = "http://127.0.0.1/search?q=hammer"
+//
+//	[[request]]
+//	name = "cart"
+//	weight = 5
+//	method = "POST"
+//	url = "http://127.0.0.1/cart"
+//	header = "Content-Type: application/json"
+//	body = "{\"item\": 42}"
+//
+// The method defaults to GET, the weight to 1 and the name to the method
+// and path. The header key can be repeated, as can the extract key of
+// -flow scenarios (see extractor).
+func read_scenario(name string) (*scenario, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := parse_scenario(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return s, nil
+}
+
+func parse_scenario(r io.Reader) (*scenario, error) {
+	s := &scenario{}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(strip_comment(sc.Text()))
+		if text == "" {
+			continue
+		}
+		if text == "[[request]]" {
+			s.endpoints = append(s.endpoints, endpoint{method: "GET", weight: 1})
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok || len(s.endpoints) == 0 {
+			return nil, fmt.Errorf("line %d: expected [[request]] or key = value", line)
+		}
+		if err := set_scenario_key(&s.endpoints[len(s.endpoints)-1], strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.endpoints) == 0 {
+		return nil, errorString("no [[request]] in scenario")
+	}
+	for i, e := range s.endpoints {
+		if e.url == nil {
+			return nil, fmt.Errorf("request %d has no url", i+1)
+		}
+	}
+	s.prepare()
+	return s, nil
+}
+
+// prepare names the endpoints left unnamed and sums their weights.
+func (s *scenario) prepare() {
+	total := 0.0
+	for i := range s.endpoints {
+		e := &s.endpoints[i]
+		if e.name == "" {
+			e.name = e.method + " " + e.url.Path
+		}
+		total += e.weight
+		s.cumulative = append(s.cumulative, total)
+	}
+}
+
+// parse_weights parses a comma-separated list of NAME=WEIGHT (or NAME, of
+// weight 1) selecting the requests of a scenario, and returns the weights by
+// name and the names in order.
+func parse_weights(list string) (map[string]float64, []string, error) {
+	weights := make(map[string]float64)
+	var order []string
+	for _, sel := range strings.Split(list, ",") {
+		if sel = strings.TrimSpace(sel); sel == "" {
+			continue
+		}
+		name, w, found := strings.Cut(sel, "=")
+		weight := 1.0
+		if found {
+			var err error
+			if weight, err = strconv.ParseFloat(w, 64); err != nil || weight <= 0 {
+				return nil, nil, fmt.Errorf("Invalid weight of request %q", name)
+			}
+		}
+		weights[name] = weight
+		order = append(order, name)
+	}
+	return weights, order, nil
+}
+
+// set_scenario_key sets an endpoint field from a key = value line.
+func set_scenario_key(e *endpoint, key, value string) error {
+	if key == "weight" {
+		var err error
+		e.weight, err = strconv.ParseFloat(value, 64)
+		if err == nil && e.weight <= 0 {
+			err = errorString("weight must be positive")
+		}
+		return err
+	}
+	v, err := strconv.Unquote(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a quoted string", key)
+	}
+	switch key {
+	case "name":
+		e.name = v
+	case "method":
+		e.method = v
+	case "url":
+		e.raw = v
+		e.url, err = neturl.Parse(v)
+		if err == nil && (e.url.Scheme != "http" && e.url.Scheme != "https" || e.url.Host == "") {
+			err = fmt.Errorf("%q is not an http or https URL", v)
+		}
+	case "body":
+		e.body = v
+	case "header":
+		err = e.hdr.Set(v)
+	case "extract":
+		var x extractor
+		if x, err = parse_extractor(v); err == nil {
+			e.extract = append(e.extract, x)
+		}
+	default:
+		err = fmt.Errorf("unknown key %q", key)
+	}
+	return err
+}
+
+// init allocates the per-endpoint statistics.
+func (s *scenario) init() {
+	for range s.endpoints {
+		s.stats = append(s.stats, &endpoint_stats{st: new_breakdown_stats()})
+	}
+}
+
+// pick draws the index of the endpoint of the next request.
+func (s *scenario) pick() int {
+	x := rand.Float64() * s.cumulative[len(s.cumulative)-1]
+	return min(sort.SearchFloat64s(s.cumulative, x), len(s.endpoints)-1)
+}
+
+// endpoint_report holds the results of one endpoint of the scenario.
+type endpoint_report struct {
+	Name        string         `json:"name"`
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	Share       float64        `json:"share"` // percentage of the requests drawn, from the weights
+	Requests    int64          `json:"requests"`
+	Errors      int64          `json:"errors"`
+	Throughput  float64        `json:"throughput_tps"`
+	Latency     latency_report `json:"latency_ms"`
+	StatusCodes map[int]int64  `json:"status_codes"`
+}
+
+// add_endpoints adds the per-endpoint results of the scenario.
+func (r *report) add_endpoints(s *scenario) {
+	total := s.cumulative[len(s.cumulative)-1]
+	for k, e := range s.endpoints {
+		st := s.stats[k].st
+		er := endpoint_report{
+			Name:        e.name,
+			Method:      e.method,
+			URL:         e.url.String(),
+			Share:       100 * e.weight / total,
+			Requests:    st.requests,
+			Errors:      st.errors,
+			Latency:     new_latency_report(st.latency),
+			StatusCodes: st.codes,
+		}
+		if r.Duration > 0 {
+			er.Throughput = float64(st.requests) / r.Duration
+		}
+		r.Endpoints = append(r.Endpoints, er)
+	}
+}
+
+// print_endpoints writes the per-endpoint block of the summary.
+func print_endpoints(w io.Writer, endpoints []endpoint_report) {
+	if len(endpoints) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Endpoints:\n  %-24s %7s %10s %8s %8s %12s %10s %10s\n", "endpoint", "share", "requests", "errors", "non-2xx", "tps", "p50 ms", "p99 ms")
+	for _, e := range endpoints {
+		var non_2xx int64
+		for code, n := range e.StatusCodes {
+			if code/100 != 2 {
+				non_2xx += n
+			}
+		}
+		fmt.Fprintf(w, "  %-24s %6.1f%% %10d %8d %8d %12.2f %10.3f %10.3f\n",
+			e.Name, e.Share, e.Requests, e.Errors, non_2xx, e.Throughput, e.Latency.percentile(50), e.Latency.percentile(99))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// knee_report holds the outcome of a search for the maximum throughput.
+type knee_report struct {
+	Concurrency int     `json:"concurrency"`
+	Throughput  float64 `json:"throughput_tps"`
+	P99         float64 `json:"p99_ms"`
+	Reason      string  `json:"reason"`
+}
+
+// search_knee raises the number of active workers step by step (doubling
+// it up to max_conc), until throughput improves by less than min_gain
+// percent, or the 99th percentile latency exceeds max_p99 if set. The
+// step with the best throughput within the latency limit is the knee.
+func search_knee(j *job, max_conc int, step time.Duration, min_gain percent, max_p99 time.Duration, sr *stage_recorder) *knee_report {
+	var knee *knee_report
+	reason := fmt.Sprintf("reached the maximum concurrency (%d)", max_conc)
+	for conc := 1; ; conc = min(2*conc, max_conc) {
+		if !run_stage(stage{name: "search", conc: conc, duration: step}, j, sr) {
+			reason = "run stopped"
+			break
+		}
+		st, length := sr.last()
+		tps := float64(st.requests) / length.Seconds()
+		p99 := usec_to_ms(float64(st.latency.value_at_percentile(99)))
+		if max_p99 > 0 && p99 > float64(max_p99)/float64(time.Millisecond) {
+			reason = fmt.Sprintf("p99 latency exceeded %s with %d connections", max_p99, conc)
+			break
+		}
+		if knee != nil && tps < knee.Throughput*(1+float64(min_gain)/100) {
+			reason = fmt.Sprintf("throughput improved by less than %s with %d connections", min_gain.String(), conc)
+			if tps > knee.Throughput {
+				knee = &knee_report{conc, tps, p99, ""}
+			}
+			break
+		}
+		knee = &knee_report{conc, tps, p99, ""}
+		if conc == max_conc {
+			break
+		}
+	}
+	stop_workers()
+	if knee == nil {
+		knee = &knee_report{}
+	}
+	knee.Reason = reason
+	return knee
+}
+
+// print_knee writes the outcome of the search for the maximum throughput.
+func print_knee(w io.Writer, k *knee_report) {
+	if k == nil {
+		return
+	}
+	if k.Concurrency == 0 {
+		fmt.Fprintf(w, "No knee point found: %s\n", k.Reason)
+		return
+	}
+	fmt.Fprintf(w, "Knee point: %d connections, %.2f tps, p99 %.3f ms (search ended: %s)\n", k.Concurrency, k.Throughput, k.P99, k.Reason)
+}
+
+// capacity_report holds the outcome of a search for the capacity: the
+// highest rate meeting the latency objective.
+type capacity_report struct {
+	Rate   float64 `json:"rate"`
+	P99    float64 `json:"p99_ms"`
+	Probes int     `json:"probes"`
+	Reason string  `json:"reason"`
+}
+
+// probe_passed tells whether a probe at rate met the latency objective and
+// actually achieved its rate (within 10%, or workers were saturated).
+func probe_passed(st *stats, length time.Duration, rate float64, max_p99 time.Duration) (bool, float64) {
+	p99 := usec_to_ms(float64(st.latency.value_at_percentile(99)))
+	tps := float64(st.requests) / length.Seconds()
+	return st.requests > 0 && p99 <= float64(max_p99)/float64(time.Millisecond) && tps >= 0.9*rate, p99
+}
+
+// search_capacity runs probes at different rates: doubling from initial
+// until a probe fails (or up to max_rate if set), then binary searching
+// between the last passing and the first failing rates until they are
+// within precision percent of each other.
+func search_capacity(j *job, initial, max_rate float64, step time.Duration, precision percent, max_p99 time.Duration, sr *stage_recorder) *capacity_report {
+	c := &capacity_report{}
+	probe := func(rate float64) (bool, bool) {
+		c.Probes++
+		if !run_stage(stage{name: "probe", rate: rate, duration: step}, j, sr) {
+			return false, false
+		}
+		st, length := sr.last()
+		ok, p99 := probe_passed(st, length, rate, max_p99)
+		if ok {
+			c.Rate, c.P99 = rate, p99
+		}
+		return ok, true
+	}
+
+	lo, hi := 0.0, max_rate
+	rate := initial
+	for hi == 0 || rate < hi {
+		ok, ran := probe(rate)
+		if !ran {
+			c.Reason = "run stopped"
+			stop_workers()
+			return c
+		}
+		if !ok {
+			hi = rate
+			break
+		}
+		lo = rate
+		rate *= 2
+		if max_rate > 0 {
+			rate = min(rate, max_rate)
+			if lo == max_rate {
+				break
+			}
+		}
+	}
+	c.Reason = fmt.Sprintf("within %s", precision.String())
+	for lo > 0 && hi > lo*(1+float64(precision)/100) {
+		mid := (lo + hi) / 2
+		ok, ran := probe(mid)
+		if !ran {
+			c.Reason = "run stopped"
+			break
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	switch {
+	case lo == 0:
+		c.Reason = fmt.Sprintf("the first probe at %g rps failed", initial)
+	case lo == max_rate:
+		c.Reason = fmt.Sprintf("reached the maximum rate (%g rps)", max_rate)
+	}
+	stop_workers()
+	return c
+}
+
+// print_capacity writes the outcome of the search for the capacity.
+func print_capacity(w io.Writer, c *capacity_report) {
+	if c == nil {
+		return
+	}
+	if c.Rate == 0 {
+		fmt.Fprintf(w, "No capacity found after %d probes: %s\n", c.Probes, c.Reason)
+		return
+	}
+	fmt.Fprintf(w, "Capacity: %.2f rps, p99 %.3f ms (%d probes, %s)\n", c.Rate, c.P99, c.Probes, c.Reason)
+}
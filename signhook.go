@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// sign_hook is the -sign-cmd coprocess: for each request, it is written a
+// JSON line describing the request, and answers with a JSON line of the
+// headers to set, {"headers": {"X-Signature": "..."}}. Requests are signed
+// one at a time. A command not answering within the timeout is killed, and
+// started again for the next request.
+type sign_hook struct {
+	mu      sync.Mutex
+	command string
+	timeout time.Duration
+	proc    *sign_proc // nil until started again
+}
+
+// sign_proc is a running hook command.
+type sign_proc struct {
+	cmd     *exec.Cmd
+	in      io.WriteCloser
+	enc     *json.Encoder
+	replies chan sign_reply
+	dead    chan struct{} // closed once killed
+}
+
+type sign_reply struct {
+	line []byte
+	err  error
+}
+
+// sign_request is the description of a request written to the hook.
+type sign_request struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+// sign_error is the failure to sign a request.
+type sign_error string
+
+func (e sign_error) Error() string {
+	return "Signing command: " + string(e)
+}
+
+// start_sign_hook starts the hook command with the shell.
+func start_sign_hook(command string, timeout time.Duration) (*sign_hook, error) {
+	h := &sign_hook{command: command, timeout: timeout}
+	var err error
+	h.proc, err = start_sign_proc(command)
+	return h, err
+}
+
+func start_sign_proc(command string) (*sign_proc, error) {
+	p := &sign_proc{
+		cmd:     exec.Command("/bin/sh", "-c", command),
+		replies: make(chan sign_reply),
+		dead:    make(chan struct{}),
+	}
+	p.cmd.Stderr = os.Stderr
+	// In its own process group, to kill the commands started by the shell
+	p.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	var err error
+	if p.in, err = p.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	out, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p.enc = json.NewEncoder(p.in)
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		r := bufio.NewReader(out)
+		for {
+			line, err := r.ReadBytes('\n')
+			if !p.reply(sign_reply{line, err}) || err != nil {
+				return
+			}
+		}
+	}()
+	return p, nil
+}
+
+// reply hands a reply to the request being signed, or returns false if
+// the command was killed.
+func (p *sign_proc) reply(r sign_reply) bool {
+	select {
+	case p.replies <- r:
+		return true
+	case <-p.dead:
+		return false
+	}
+}
+
+func (p *sign_proc) kill() {
+	close(p.dead)
+	syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+	p.cmd.Wait()
+}
+
+// sign sets on req, whose body is given, the headers returned by the hook.
+func (h *sign_hook) sign(req *http.Request, body string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.proc == nil {
+		var err error
+		if h.proc, err = start_sign_proc(h.command); err != nil {
+			return sign_error(err.Error())
+		}
+	}
+	p := h.proc
+	// Written meanwhile, as a command not reading its input blocks writes
+	r := sign_request{req.Method, req.URL.String(), req.Header, body}
+	go func() {
+		if err := p.enc.Encode(r); err != nil {
+			p.reply(sign_reply{err: err})
+		}
+	}()
+	timer := time.NewTimer(h.timeout)
+	defer timer.Stop()
+	var reply sign_reply
+	select {
+	case reply = <-p.replies:
+	case <-timer.C:
+		reply.err = fmt.Errorf("no answer within %s, restarting it", h.timeout)
+	case <-stop_ch:
+		reply.err = errorString("run stopped")
+	}
+	if reply.err != nil {
+		p.kill()
+		h.proc = nil
+		return sign_error(reply.err.Error())
+	}
+	var resp struct {
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(reply.line, &resp); err != nil {
+		return sign_error("invalid output: " + err.Error())
+	}
+	for name, value := range resp.Headers {
+		req.Header.Set(name, value)
+	}
+	return nil
+}
+
+// close ends the input of the hook and waits for it to exit, killing it
+// after the timeout.
+func (h *sign_hook) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.proc == nil {
+		return
+	}
+	close(h.proc.dead)
+	h.proc.in.Close()
+	exited := make(chan struct{})
+	go func() {
+		h.proc.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(h.timeout):
+		syscall.Kill(-h.proc.cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+	}
+}
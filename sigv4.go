@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sigv4_signer signs requests with AWS Signature Version 4, with
+// credentials from the environment.
+type sigv4_signer struct {
+	region, service string
+	key_id, secret  string
+	session_token   string // empty unless temporary credentials
+}
+
+// new_sigv4_signer returns a signer for a region/service -aws-sigv4 value.
+func new_sigv4_signer(spec string) (*sigv4_signer, error) {
+	region, service, ok := strings.Cut(spec, "/")
+	if !ok || region == "" || service == "" {
+		return nil, errorString("-aws-sigv4 format must be `region/service'")
+	}
+	s := &sigv4_signer{
+		region:        region,
+		service:       service,
+		key_id:        os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret:        os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		session_token: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if s.key_id == "" || s.secret == "" {
+		return nil, errorString("-aws-sigv4 requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY in the environment")
+	}
+	return s, nil
+}
+
+// payload_hash returns the hex SHA-256 of a request body.
+func payload_hash(body string) string {
+	h := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(h[:])
+}
+
+// uri_encode escapes all but the unreserved characters of RFC 3986, and
+// slashes too unless keep_slash.
+func uri_encode(s string, keep_slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keep_slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmac_sha256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// sign sets the authentication headers of req, whose body has the given
+// hash, as of time t.
+func (s *sigv4_signer) sign(req *http.Request, hash string, t time.Time) {
+	t = t.UTC()
+	amz_date, date := t.Format("20060102T150405Z"), t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amz_date)
+	if s.session_token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.session_token)
+	}
+	if s.service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", hash)
+	}
+
+	// S3 paths are encoded once, those of other services twice
+	path := uri_encode(req.URL.Path, true)
+	if path == "" {
+		path = "/"
+	}
+	if s.service != "s3" {
+		path = uri_encode(path, true)
+	}
+	// Parameters sorted by encoded name, then value
+	var params [][2]string
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			params = append(params, [2]string{uri_encode(k, false), uri_encode(v, false)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	query := make([]string, len(params))
+	for i, p := range params {
+		query[i] = p[0] + "=" + p[1]
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, vs := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.Join(strings.Fields(strings.Join(vs, ",")), " ")
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, path, strings.Join(query, "&"))
+	for _, name := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", name, headers[name])
+	}
+	signed := strings.Join(names, ";")
+	fmt.Fprintf(&canonical, "\n%s\n%s", signed, hash)
+
+	scope := date + "/" + s.region + "/" + s.service + "/aws4_request"
+	digest := sha256.Sum256([]byte(canonical.String()))
+	to_sign := "AWS4-HMAC-SHA256\n" + amz_date + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+	key := hmac_sha256([]byte("AWS4"+s.secret), date)
+	key = hmac_sha256(key, s.region)
+	key = hmac_sha256(key, s.service)
+	key = hmac_sha256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.key_id, scope, signed, hex.EncodeToString(hmac_sha256(key, to_sign))))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sink streams the statistics of each sampling interval to a metrics
+// server, either StatsD or InfluxDB (line protocol), over UDP or TCP.
+type sink struct {
+	conn   net.Conn
+	influx bool
+	prefix string
+	target string
+}
+
+// new_sink connects to the metrics server described by spec, e.g.
+// "statsd://localhost:8125" or "influx+tcp://localhost:8089".
+func new_sink(spec, prefix, target string) (*sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	scheme, proto, _ := strings.Cut(u.Scheme, "+")
+	if proto == "" {
+		proto = "udp"
+	}
+	if proto != "udp" && proto != "tcp" {
+		return nil, fmt.Errorf("Unknown sink transport %q", proto)
+	}
+	s := &sink{prefix: prefix, target: target}
+	switch scheme {
+	case "statsd":
+	case "influx":
+		s.influx = true
+	default:
+		return nil, fmt.Errorf("Unknown sink type %q (statsd or influx)", scheme)
+	}
+	s.conn, err = net.Dial(proto, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// sample is the sample_hook sending the figures of one interval.
+func (sk *sink) sample(elapsed, interval time.Duration, s *stats) {
+	var tps float64
+	if interval > 0 {
+		tps = float64(s.requests) / interval.Seconds()
+	}
+	p50 := usec_to_ms(float64(s.latency.value_at_percentile(50)))
+	p90 := usec_to_ms(float64(s.latency.value_at_percentile(90)))
+	p99 := usec_to_ms(float64(s.latency.value_at_percentile(99)))
+	max := usec_to_ms(float64(s.latency.maximum()))
+
+	var b bytes.Buffer
+	if sk.influx {
+		fmt.Fprintf(&b, "%s,target=%s requests=%di,errors=%di,bytes=%di,throughput=%g,p50=%g,p90=%g,p99=%g,max=%g %d\n",
+			sk.prefix, influx_escape(sk.target), s.requests, s.errors, s.bytes, tps, p50, p90, p99, max, time.Now().UnixNano())
+	} else {
+		fmt.Fprintf(&b, "%s.requests:%d|c\n%s.errors:%d|c\n%s.bytes:%d|c\n", sk.prefix, s.requests, sk.prefix, s.errors, sk.prefix, s.bytes)
+		fmt.Fprintf(&b, "%s.throughput:%g|g\n", sk.prefix, tps)
+		if s.requests != 0 {
+			fmt.Fprintf(&b, "%s.latency.p50:%g|g\n%s.latency.p90:%g|g\n%s.latency.p99:%g|g\n%s.latency.max:%g|g\n",
+				sk.prefix, p50, sk.prefix, p90, sk.prefix, p99, sk.prefix, max)
+		}
+		for code, n := range s.codes {
+			fmt.Fprintf(&b, "%s.status.%d:%d|c\n", sk.prefix, code, n)
+		}
+	}
+	if _, err := sk.conn.Write(b.Bytes()); err != nil {
+		log_error("metrics sink", err)
+	}
+}
+
+// influx_escape escapes a tag value of the InfluxDB line protocol.
+func influx_escape(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// percent is a flag.Value holding a percentage, written "1.5%" or "1.5".
+type percent float64
+
+func (p *percent) String() string {
+	return fmt.Sprintf("%g%%", float64(*p))
+}
+
+func (p *percent) Set(value string) error {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || v < 0 || v > 100 {
+		return errorString("Percentage format must be `1.5%' (0 to 100)")
+	}
+	*p = percent(v)
+	return nil
+}
+
+// sla holds the thresholds a run must satisfy, zero values being unchecked.
+type sla struct {
+	max_p50        time.Duration
+	max_p90        time.Duration
+	max_p99        time.Duration
+	max_mean       time.Duration
+	max_error_rate percent
+	min_throughput float64
+}
+
+// assertion is the outcome of checking one threshold.
+type assertion struct {
+	Name   string `json:"name"`
+	Limit  string `json:"limit"`
+	Actual string `json:"actual"`
+	Passed bool   `json:"passed"`
+}
+
+// error_rate returns the percentage of failed requests of a run: transport
+// errors, 5xx responses and gRPC calls with a status other than OK.
+func error_rate(r *report) float64 {
+	failed := r.Errors
+	for code, n := range r.StatusCodes {
+		if code >= 500 {
+			failed += n
+		}
+	}
+	for status, n := range r.GRPCStatus {
+		if status != "OK" {
+			failed += n
+		}
+	}
+	if total := r.Requests + r.Errors; total > 0 {
+		return float64(failed) * 100 / float64(total)
+	}
+	return 0
+}
+
+// percentile returns the latency percentile p of a run, in milliseconds.
+func (l *latency_report) percentile(p float64) float64 {
+	for _, pr := range l.Percentiles {
+		if pr.Percentile == p {
+			return pr.Value
+		}
+	}
+	return 0
+}
+
+func ms_duration(ms float64) string {
+	return time.Duration(ms * float64(time.Millisecond)).String()
+}
+
+// check returns the outcome of every threshold set.
+func (s *sla) check(r *report) []assertion {
+	var res []assertion
+	latency := func(name string, limit time.Duration, actual float64) {
+		if limit > 0 {
+			res = append(res, assertion{
+				Name:   name,
+				Limit:  "<= " + limit.String(),
+				Actual: ms_duration(actual),
+				Passed: actual <= float64(limit)/float64(time.Millisecond),
+			})
+		}
+	}
+	latency("p50 latency", s.max_p50, r.Latency.percentile(50))
+	latency("p90 latency", s.max_p90, r.Latency.percentile(90))
+	latency("p99 latency", s.max_p99, r.Latency.percentile(99))
+	latency("mean latency", s.max_mean, r.Latency.Mean)
+	if s.max_error_rate > 0 {
+		rate := error_rate(r)
+		res = append(res, assertion{
+			Name:   "error rate",
+			Limit:  "<= " + s.max_error_rate.String(),
+			Actual: fmt.Sprintf("%.3f%%", rate),
+			Passed: rate <= float64(s.max_error_rate),
+		})
+	}
+	if s.min_throughput > 0 {
+		res = append(res, assertion{
+			Name:   "throughput",
+			Limit:  fmt.Sprintf(">= %g tps", s.min_throughput),
+			Actual: fmt.Sprintf("%.2f tps", r.Throughput),
+			Passed: r.Throughput >= s.min_throughput,
+		})
+	}
+	return res
+}
+
+// passed tells whether all the assertions passed.
+func passed(assertions []assertion) bool {
+	for _, a := range assertions {
+		if !a.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// print_assertions writes the threshold block of the summary.
+func print_assertions(w io.Writer, assertions []assertion) {
+	if len(assertions) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Thresholds:")
+	for _, a := range assertions {
+		status := "PASS"
+		if !a.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "  %s %s %s: %s\n", status, a.Name, a.Limit, a.Actual)
+	}
+}
+
+// breaker aborts the run when the error rate (transport errors and 5xx
+// responses) observed since the beginning exceeds a threshold.
+type breaker struct {
+	limit    percent
+	requests int64
+	failed   int64
+}
+
+// Number of requests to observe before the error rate is meaningful
+const breaker_min_requests = 100
+
+// sample is the sample_hook checking the error rate.
+func (b *breaker) sample(elapsed, interval time.Duration, s *stats) {
+	b.requests += s.requests + s.errors
+	b.failed += s.errors
+	for code, n := range s.codes {
+		if code >= 500 {
+			b.failed += n
+		}
+	}
+	if b.requests < breaker_min_requests {
+		return
+	}
+	if rate := float64(b.failed) * 100 / float64(b.requests); rate > float64(b.limit) {
+		abort_run(fmt.Sprintf("error rate %.2f%% exceeded %s after %s", rate, b.limit.String(), elapsed.Truncate(time.Second)))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// soak writes interim summaries of long runs: every period, the results
+// since the beginning are printed and, if a directory is set, saved there
+// along with the latency histogram of the period. Files are renamed into
+// place once complete, so interim results survive a crash.
+type soak struct {
+	cfg    report_config
+	every  time.Duration
+	dir    string
+	out    io.Writer
+	total  *stats // since the beginning of the run
+	period *stats // since the last summary
+	since  time.Duration
+	n      int
+}
+
+func new_soak(cfg report_config, every time.Duration, dir string, out io.Writer) *soak {
+	return &soak{cfg: cfg, every: every, dir: dir, out: out, total: new_stats(), period: new_stats()}
+}
+
+// sample is the sample_hook accumulating statistics and writing the
+// summary when due.
+func (sk *soak) sample(elapsed, interval time.Duration, s *stats) {
+	sk.total.merge(s)
+	sk.period.merge(s)
+	if elapsed-sk.since+interval/2 < sk.every {
+		return
+	}
+	sk.since = elapsed
+	sk.n++
+	end := time.Now()
+	r := new_report(sk.cfg, sk.total, end.Add(-elapsed), end)
+	fmt.Fprintf(sk.out, "=== Interim summary %d after %s ===\n", sk.n, elapsed.Truncate(time.Second))
+	write_text(sk.out, r, sk.total)
+	if sk.dir != "" {
+		if err := sk.save(r); err != nil {
+			log_error("soak summary", err)
+		}
+	}
+	sk.period = new_stats()
+}
+
+// save writes the interim results and the histogram of the period.
+func (sk *soak) save(r *report) error {
+	err := save_file(filepath.Join(sk.dir, fmt.Sprintf("summary-%04d.json", sk.n)), func(w io.Writer) error {
+		return write_json(w, r)
+	})
+	if err != nil {
+		return err
+	}
+	return save_file(filepath.Join(sk.dir, fmt.Sprintf("period-%04d.hgrm", sk.n)), func(w io.Writer) error {
+		return sk.period.latency.write_hgrm(w, 1000)
+	})
+}
+
+// save_file writes a file under a temporary name, then renames it.
+func save_file(name string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".hammer-*")
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// make_soak_dir creates the directory of interim results.
+func make_soak_dir(dir string) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sse_stats holds the statistics of the event streams of one worker.
+type sse_stats struct {
+	connections int64
+	drops       int64 // streams ended by the server or an error before the end of the run
+	events      int64
+	first       *histogram // time from the request to the first event
+	gap         *histogram // time between consecutive events of a stream
+}
+
+func new_sse_stats() *sse_stats {
+	return &sse_stats{
+		first: new_histogram(latency_lowest, latency_highest, latency_sigfigs),
+		gap:   new_histogram(latency_lowest, latency_highest, latency_sigfigs),
+	}
+}
+
+func (s *sse_stats) merge(o *sse_stats) {
+	s.connections += o.connections
+	s.drops += o.drops
+	s.events += o.events
+	s.first.merge(o.first)
+	s.gap.merge(o.gap)
+}
+
+// counting_reader counts the bytes read through it.
+type counting_reader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *counting_reader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// read_events reads a text/event-stream, calling event for each event
+// dispatched, until the end of the stream or an error.
+func read_events(r io.Reader, event func()) error {
+	br := bufio.NewReader(r)
+	data := false
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case err != nil:
+			return err
+		case line == "":
+			// A blank line dispatches the event, if it has data
+			if data {
+				event()
+			}
+			data = false
+		case strings.HasPrefix(line, "data:") || line == "data":
+			data = true
+		}
+	}
+}
+
+// consume_events is the worker of -sse mode: it keeps an event stream open
+// until the run is stopped, reconnecting when the stream is dropped. Each
+// stream counts as a request, its duration being the latency.
+func consume_events(j *job, id int, delay time.Duration, st *stats, ss *sse_stats) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop_ch:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		log_error(err_other, err)
+		ready_ch <- true
+		<-start_ch
+		done_ch <- true
+		return
+	}
+	for _, hf := range j.hdr {
+		req.Header.Add(hf.name, hf.value)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if j.user != "" {
+		req.SetBasicAuth(j.user, j.pass)
+	}
+
+	ready_ch <- true
+	<-start_ch
+	if !pause(delay) {
+		done_ch <- true
+		return
+	}
+
+	for !stopped() {
+		if j.gate != nil && !j.gate.wait(id) {
+			break
+		}
+		sent := time.Now()
+		resp, err := j.client.Do(req)
+		if err != nil {
+			if stopped() {
+				break
+			}
+			kind := classify_error(err)
+			log_error(kind, err)
+			st.record_error(kind)
+			// Do not hammer a failing server with reconnections
+			pause(100 * time.Millisecond)
+			continue
+		}
+		st.record_ttfb(time.Since(sent))
+		st.record_status(resp.StatusCode)
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			st.record_latency(time.Since(sent))
+			pause(100 * time.Millisecond)
+			continue
+		}
+		ss.connections++
+		last, n := sent, 0
+		body := &counting_reader{r: resp.Body}
+		read_events(body, func() {
+			now := time.Now()
+			if n == 0 {
+				ss.first.record(int64(now.Sub(sent) / time.Microsecond))
+			} else {
+				ss.gap.record(int64(now.Sub(last) / time.Microsecond))
+			}
+			last = now
+			n++
+			ss.events++
+		})
+		resp.Body.Close()
+		st.record_latency(time.Since(sent))
+		st.record_bytes(body.n)
+		if !stopped() {
+			ss.drops++
+		}
+	}
+	done_ch <- true
+}
+
+// sse_report holds the event stream statistics of a -sse run.
+type sse_report struct {
+	Connections   int64          `json:"connections"`
+	Drops         int64          `json:"drops"`
+	DropRate      float64        `json:"drop_rate_percent"`
+	Events        int64          `json:"events"`
+	EventRate     float64        `json:"events_per_second"`
+	EventsPerConn float64        `json:"events_per_connection"`
+	FirstEvent    latency_report `json:"first_event_ms"`
+	Gap           latency_report `json:"event_gap_ms"`
+}
+
+func new_sse_report(ss *sse_stats, elapsed float64) *sse_report {
+	r := &sse_report{
+		Connections: ss.connections,
+		Drops:       ss.drops,
+		Events:      ss.events,
+		FirstEvent:  new_latency_report(ss.first),
+		Gap:         new_latency_report(ss.gap),
+	}
+	if ss.connections > 0 {
+		r.DropRate = float64(ss.drops) * 100 / float64(ss.connections)
+		r.EventsPerConn = float64(ss.events) / float64(ss.connections)
+	}
+	if elapsed > 0 {
+		r.EventRate = float64(ss.events) / elapsed
+	}
+	return r
+}
+
+// print_sse writes the event stream block of the summary.
+func print_sse(w io.Writer, r *sse_report) {
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(w, "Events: %d received, %.2f events/s, %.1f per connection\n", r.Events, r.EventRate, r.EventsPerConn)
+	fmt.Fprintf(w, "Streams: %d opened, %d dropped (%.2f%%)\n", r.Connections, r.Drops, r.DropRate)
+	fmt.Fprintf(w, "First event (ms): p50 %.3f, p99 %.3f, max %.3f\n", r.FirstEvent.percentile(50), r.FirstEvent.percentile(99), r.FirstEvent.Max)
+	fmt.Fprintf(w, "Event gap (ms): p50 %.3f, p99 %.3f, max %.3f\n", r.Gap.percentile(50), r.Gap.percentile(99), r.Gap.Max)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stage is one step of a load profile.
+type stage struct {
+	name     string  // optional label shown in reports
+	rate     float64 // requests per second, 0 to keep the previous rate
+	conc     int     // active workers, 0 to keep the previous number
+	duration time.Duration
+	url      *url.URL      // target, nil to keep the previous one
+	cooldown time.Duration // idle gap after the stage, unless the last one
+}
+
+func (s stage) String() string {
+	var parts []string
+	if s.rate > 0 {
+		parts = append(parts, strconv.FormatFloat(s.rate, 'g', -1, 64)+"rps")
+	}
+	if s.conc > 0 {
+		parts = append(parts, strconv.Itoa(s.conc)+"c")
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "same")
+	}
+	if s.url != nil {
+		parts = append(parts, s.url.Path)
+	}
+	desc := strings.Join(parts, "+") + ":" + s.duration.String()
+	if s.name != "" {
+		return s.name + " " + desc
+	}
+	return desc
+}
+
+// parse_stages parses a load profile such as "50rps:1m,100rps:1m,200rps:2m".
+// Each stage sets a rate (Nrps), a number of active workers (Nc) or both
+// (Nrps+Nc), for the given duration.
+func parse_stages(spec string) ([]stage, error) {
+	var stages []stage
+	for _, item := range strings.Split(spec, ",") {
+		load, length, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			return nil, fmt.Errorf("Stage %q must be formatted as LOAD:DURATION", item)
+		}
+		var s stage
+		var err error
+		if s.duration, err = time.ParseDuration(length); err != nil || s.duration <= 0 {
+			return nil, fmt.Errorf("Invalid duration in stage %q", item)
+		}
+		for _, l := range strings.Split(load, "+") {
+			switch {
+			case strings.HasSuffix(l, "rps"):
+				s.rate, err = strconv.ParseFloat(strings.TrimSuffix(l, "rps"), 64)
+				if err == nil && s.rate <= 0 {
+					err = errorString("rate must be positive")
+				}
+			case strings.HasSuffix(l, "c"):
+				s.conc, err = strconv.Atoi(strings.TrimSuffix(l, "c"))
+				if err == nil && s.conc <= 0 {
+					err = errorString("concurrency must be positive")
+				}
+			default:
+				err = errorString("load must be Nrps or Nc")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Invalid load %q in stage %q: %v", l, item, err)
+			}
+		}
+		stages = append(stages, s)
+	}
+	return stages, nil
+}
+
+// spike_stages returns the stages of a spike test: the baseline rate and
+// concurrency for the first half of the run, then a burst of load described
+// by spec (e.g. "1000rps:10s"), then the baseline again until the end of
+// the run.
+func spike_stages(spec string, baseline float64, conc int, total time.Duration) ([]stage, error) {
+	burst, err := parse_stages(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(burst) != 1 {
+		return nil, fmt.Errorf("Spike %q must be a single LOAD:DURATION stage", spec)
+	}
+	if baseline <= 0 || total <= burst[0].duration {
+		return nil, errorString("A spike test requires -rate and a -duration longer than the spike")
+	}
+	spike := burst[0]
+	spike.name = "spike"
+	if spike.rate == 0 {
+		spike.rate = baseline
+	}
+	base_conc := 0
+	if spike.conc > 0 {
+		base_conc = conc
+	}
+	before := (total - spike.duration) / 2
+	return []stage{
+		{name: "baseline", rate: baseline, conc: base_conc, duration: before},
+		spike,
+		{name: "recovery", rate: baseline, conc: base_conc, duration: total - before - spike.duration},
+	}, nil
+}
+
+// stage_recorder accumulates the live statistics of each stage.
+type stage_recorder struct {
+	mu      sync.Mutex
+	stages  []stage
+	stats   []*stats
+	lengths []time.Duration
+	running bool          // a stage is in progress
+	idle    time.Duration // total of the cool-down gaps
+}
+
+// begin starts recording a new stage.
+func (sr *stage_recorder) begin(s stage) {
+	sr.mu.Lock()
+	sr.stages = append(sr.stages, s)
+	sr.stats = append(sr.stats, new_stats())
+	sr.lengths = append(sr.lengths, 0)
+	sr.running = true
+	sr.mu.Unlock()
+}
+
+// end stops recording the current stage, after flushing the live sampler
+// so that the statistics of its last interval are accounted to it.
+func (sr *stage_recorder) end() {
+	live.flush()
+	sr.mu.Lock()
+	sr.running = false
+	sr.mu.Unlock()
+}
+
+// last returns the statistics and actual duration of the last stage.
+func (sr *stage_recorder) last() (*stats, time.Duration) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	i := len(sr.stages) - 1
+	return sr.stats[i], sr.lengths[i]
+}
+
+// sample is the sample_hook adding an interval to the current stage.
+func (sr *stage_recorder) sample(elapsed, interval time.Duration, s *stats) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.running {
+		i := len(sr.stages) - 1
+		sr.stats[i].merge(s)
+		sr.lengths[i] += interval
+	}
+}
+
+// run_stage applies a stage of a load profile and waits for its end. It
+// returns false if workers were stopped meanwhile.
+func run_stage(s stage, j *job, sr *stage_recorder) bool {
+	sr.begin(s)
+	if s.rate > 0 {
+		j.pacer.set_rate(s.rate)
+	}
+	if s.conc > 0 {
+		j.gate.set(s.conc)
+	}
+	if s.url != nil {
+		j.target.Store(s.url)
+	}
+	ok := pause(s.duration)
+	sr.end()
+	return ok
+}
+
+// cool_down stops sending requests for d, for the target to drain its
+// queues. It returns false if workers were stopped meanwhile.
+func cool_down(d time.Duration, j *job, sr *stage_recorder) bool {
+	j.gate.pause(true)
+	started := time.Now()
+	ok := pause(d)
+	// Requests missed during the gap are not sent in a burst
+	if j.pacer != nil {
+		j.pacer.restart()
+	}
+	j.gate.pause(false)
+	sr.mu.Lock()
+	sr.idle += time.Since(started)
+	sr.mu.Unlock()
+	return ok
+}
+
+// run_stages applies the stages of a load profile one after the other, with
+// their cool-down gaps in between, then stops the workers.
+func run_stages(stages []stage, j *job, sr *stage_recorder) {
+	for i, s := range stages {
+		if !run_stage(s, j, sr) {
+			return
+		}
+		if s.cooldown > 0 && i < len(stages)-1 && !cool_down(s.cooldown, j, sr) {
+			return
+		}
+	}
+	stop_workers()
+}
+
+// stage_report holds the results of one stage of the load profile.
+type stage_report struct {
+	Stage      string         `json:"stage"`
+	Duration   float64        `json:"duration_seconds"`
+	Requests   int64          `json:"requests"`
+	Errors     int64          `json:"errors"`
+	Throughput float64        `json:"throughput_tps"`
+	Latency    latency_report `json:"latency_ms"`
+}
+
+// add_stages adds the per-stage results of the load profile.
+func (r *report) add_stages(sr *stage_recorder) {
+	for i, s := range sr.stages {
+		st := sr.stats[i]
+		sp := stage_report{
+			Stage:    s.String(),
+			Duration: sr.lengths[i].Seconds(),
+			Requests: st.requests,
+			Errors:   st.errors,
+			Latency:  new_latency_report(st.latency),
+		}
+		if sp.Duration > 0 {
+			sp.Throughput = float64(st.requests) / sp.Duration
+		}
+		r.Stages = append(r.Stages, sp)
+	}
+}
+
+// print_stages writes the per-stage block of the summary.
+func print_stages(w io.Writer, stages []stage_report) {
+	if len(stages) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Stages:\n  %-24s %8s %10s %8s %12s %10s %10s\n", "stage", "seconds", "requests", "errors", "tps", "p50 ms", "p99 ms")
+	for _, s := range stages {
+		fmt.Fprintf(w, "  %-24s %8.1f %10d %8d %12.2f %10.3f %10.3f\n",
+			s.Stage, s.Duration, s.Requests, s.Errors, s.Throughput, s.Latency.percentile(50), s.Latency.percentile(99))
+	}
+}
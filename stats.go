@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Latencies are recorded in microseconds, from 1µs up to one hour, in
+// histograms of constant size whatever the number of requests: about 190 KB
+// with 3 significant digits, 27 KB with 2. Memory usage hence only depends
+// on the number of workers, each having its own histograms.
+const (
+	latency_lowest  = 1
+	latency_highest = int64(time.Hour / time.Microsecond)
+)
+
+// latency_sigfigs is the number of significant digits of latency histograms
+var latency_sigfigs = 3
+
+// stats holds the figures collected by one worker goroutine. Each worker
+// owns its stats, so no locking is needed; they are merged by the main
+// goroutine once the run is over.
+type stats struct {
+	requests  int64
+	errors    int64
+	bytes     int64            // response body bytes received
+	redirects int64            // redirects followed
+	failures  map[string]int64 // errors per category
+	latency   *histogram
+	ttfb      *histogram                 // time to first byte
+	phases    [phase_count]*histogram    // created when first recorded
+	codes     map[int]int64              // responses per HTTP status code
+	grpc      map[int]int64              // responses per gRPC status, -1 if missing
+	cont      *histogram                 // wait for 100 Continue, created when first recorded
+	encodings map[string]*encoding_count // responses per content coding, created when first recorded
+}
+
+func new_stats() *stats {
+	return &stats{
+		latency:  new_histogram(latency_lowest, latency_highest, latency_sigfigs),
+		ttfb:     new_histogram(latency_lowest, latency_highest, latency_sigfigs),
+		codes:    make(map[int]int64),
+		failures: make(map[string]int64),
+	}
+}
+
+// new_breakdown_stats returns stats without time to first byte, for the
+// breakdowns of the results (per endpoint), which only record latencies,
+// statuses, bytes and errors.
+func new_breakdown_stats() *stats {
+	return &stats{
+		latency:  new_histogram(latency_lowest, latency_highest, latency_sigfigs),
+		codes:    make(map[int]int64),
+		failures: make(map[string]int64),
+	}
+}
+
+// record_latency records the duration of one request.
+func (s *stats) record_latency(d time.Duration) {
+	s.requests++
+	s.latency.record(int64(d / time.Microsecond))
+}
+
+// record_ttfb records the time to first byte of one request.
+func (s *stats) record_ttfb(d time.Duration) {
+	s.ttfb.record(int64(d / time.Microsecond))
+}
+
+// record_phase records the duration of one request phase.
+func (s *stats) record_phase(phase int, d time.Duration) {
+	if s.phases[phase] == nil {
+		s.phases[phase] = new_histogram(latency_lowest, latency_highest, latency_sigfigs)
+	}
+	s.phases[phase].record(int64(d / time.Microsecond))
+}
+
+// record_continue records the wait for the 100 Continue response of one
+// request, from the end of its headers.
+func (s *stats) record_continue(d time.Duration) {
+	if s.cont == nil {
+		s.cont = new_histogram(latency_lowest, latency_highest, latency_sigfigs)
+	}
+	s.cont.record(int64(d / time.Microsecond))
+}
+
+// record_encoding counts one response with a content coding, of wire
+// bytes decoded into decoded bytes.
+func (s *stats) record_encoding(enc string, wire, decoded int64) {
+	if s.encodings == nil {
+		s.encodings = make(map[string]*encoding_count)
+	}
+	c := s.encodings[enc]
+	if c == nil {
+		c = &encoding_count{}
+		s.encodings[enc] = c
+	}
+	c.responses++
+	c.wire += wire
+	c.decoded += decoded
+}
+
+// record_status counts one response with the given HTTP status code.
+func (s *stats) record_status(code int) {
+	s.codes[code]++
+}
+
+// record_grpc_status counts one response with the given gRPC status.
+func (s *stats) record_grpc_status(code int) {
+	if s.grpc == nil {
+		s.grpc = make(map[int]int64)
+	}
+	s.grpc[code]++
+}
+
+// record_bytes counts the size of one response body.
+func (s *stats) record_bytes(n int64) {
+	s.bytes += n
+}
+
+// record_redirects counts the redirects followed by one request.
+func (s *stats) record_redirects(n int64) {
+	s.redirects += n
+}
+
+// record_error counts one failed request, of the given error category.
+func (s *stats) record_error(kind string) {
+	s.errors++
+	s.failures[kind]++
+}
+
+// merge adds the figures of o to s.
+func (s *stats) merge(o *stats) {
+	s.requests += o.requests
+	s.errors += o.errors
+	s.bytes += o.bytes
+	s.redirects += o.redirects
+	s.latency.merge(o.latency)
+	if o.ttfb != nil {
+		s.ttfb.merge(o.ttfb)
+	}
+	for phase, h := range o.phases {
+		if h == nil {
+			continue
+		}
+		if s.phases[phase] == nil {
+			s.phases[phase] = new_histogram(latency_lowest, latency_highest, latency_sigfigs)
+		}
+		s.phases[phase].merge(h)
+	}
+	if o.cont != nil {
+		if s.cont == nil {
+			s.cont = new_histogram(latency_lowest, latency_highest, latency_sigfigs)
+		}
+		s.cont.merge(o.cont)
+	}
+	if len(o.encodings) != 0 && s.encodings == nil {
+		s.encodings = make(map[string]*encoding_count)
+	}
+	for enc, c := range o.encodings {
+		sc := s.encodings[enc]
+		if sc == nil {
+			sc = &encoding_count{}
+			s.encodings[enc] = sc
+		}
+		sc.responses += c.responses
+		sc.wire += c.wire
+		sc.decoded += c.decoded
+	}
+	for code, n := range o.codes {
+		s.codes[code] += n
+	}
+	for kind, n := range o.failures {
+		s.failures[kind] += n
+	}
+	if len(o.grpc) != 0 && s.grpc == nil {
+		s.grpc = make(map[int]int64)
+	}
+	for code, n := range o.grpc {
+		s.grpc[code] += n
+	}
+}
+
+// usec_to_ms converts a histogram value to milliseconds for display.
+func usec_to_ms(v float64) float64 {
+	return v / 1000
+}
+
+// print_latency writes a latency distribution block of the summary.
+func print_latency(w io.Writer, title string, h *histogram) {
+	fmt.Fprintf(w, "%s (ms): min %.3f, mean %.3f, stddev %.3f, max %.3f\n", title,
+		usec_to_ms(float64(h.minimum())), usec_to_ms(h.mean()), usec_to_ms(h.std_dev()), usec_to_ms(float64(h.maximum())))
+	for _, p := range report_percentiles {
+		fmt.Fprintf(w, "  p%-5g %10.3f\n", p, usec_to_ms(float64(h.value_at_percentile(p))))
+	}
+}
+
+// print_status_codes writes the per status class and per status code
+// response counts of the summary.
+func print_status_codes(w io.Writer, codes map[int]int64) {
+	if len(codes) == 0 {
+		return
+	}
+	var classes [6]int64
+	var other int64
+	sorted := make([]int, 0, len(codes))
+	for code, n := range codes {
+		sorted = append(sorted, code)
+		if 1 <= code/100 && code/100 <= 5 {
+			classes[code/100] += n
+		} else {
+			other += n
+		}
+	}
+	sort.Ints(sorted)
+
+	fmt.Fprint(w, "Status codes:")
+	for c := 1; c <= 5; c++ {
+		if classes[c] != 0 || c >= 2 {
+			fmt.Fprintf(w, " %dxx %d", c, classes[c])
+		}
+	}
+	if other != 0 {
+		fmt.Fprintf(w, " other %d", other)
+	}
+	fmt.Fprintln(w)
+	for _, code := range sorted {
+		fmt.Fprintf(w, "  %d %10d\n", code, codes[code])
+	}
+}
+
+// print_errors writes the per category error counts of the summary.
+func print_errors(w io.Writer, errors int64, failures map[string]int64) {
+	if errors == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Errors: %d\n", errors)
+	for _, kind := range error_kinds {
+		if n := failures[kind]; n != 0 {
+			fmt.Fprintf(w, "  %-20s %10d\n", kind, n)
+		}
+	}
+}
+
+// bar returns a bar of width proportional to v/max.
+func bar(v, max int64, width int) string {
+	if max == 0 {
+		return ""
+	}
+	return strings.Repeat("#", int(v*int64(width)/max))
+}
+
+// Upper bounds of the buckets of the latency distribution, in milliseconds
+var distribution_bounds = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// print_distribution writes an ASCII histogram of the latencies, skipping
+// the empty buckets at both ends.
+func print_distribution(w io.Writer, h *histogram) {
+	if h.count() == 0 {
+		return
+	}
+	counts := make([]int64, len(distribution_bounds)+1)
+	var below int64
+	for i, ms := range distribution_bounds {
+		n := h.count_at_or_below(int64(ms * 1000))
+		counts[i] = n - below
+		below = n
+	}
+	counts[len(distribution_bounds)] = h.count() - below
+
+	first, last := 0, len(counts)-1
+	for counts[first] == 0 {
+		first++
+	}
+	for counts[last] == 0 {
+		last--
+	}
+	var max int64
+	for _, n := range counts {
+		if n > max {
+			max = n
+		}
+	}
+	fmt.Fprintln(w, "Latency distribution:")
+	for i := first; i <= last; i++ {
+		label := "> 1000 ms"
+		if i < len(distribution_bounds) {
+			label = fmt.Sprintf("<= %g ms", distribution_bounds[i])
+		}
+		fmt.Fprintf(w, "  %10s %10d %6.2f%% %s\n", label, counts[i], float64(counts[i])*100/float64(h.count()), bar(counts[i], max, 40))
+	}
+}
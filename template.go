@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With -template, the URL, header values and body of each request have
+// their placeholders expanded:
+//
+//	{{uuid}}          random UUID (version 4)
+//	{{randint A B}}   random integer between A and B included
+//	{{timestamp}}     Unix time in seconds ({{timestamp_ms}} in milliseconds)
+//	{{seq}}           number of the request, from 1, the same in all the
+//	                  placeholders of a request
+//	{{.NAME}}         NAME variable extracted by a previous request of the
+//	                  -flow, or else NAME column of the -data row
+//
+// uuid and randint are drawn again for each placeholder.
+
+// tmpl_vars holds the values of the placeholders of one request.
+type tmpl_vars struct {
+	seq  int64
+	data *feeder
+	row  []string          // of data
+	vars map[string]string // extracted by the -flow of the virtual user
+}
+
+// check_template returns an error if s has an unknown or malformed
+// placeholder, data being the -data feeder and vars the -flow variables if
+// any.
+func check_template(s string, data *feeder, vars map[string]string) error {
+	_, err := expand(s, &tmpl_vars{seq: 1, data: data, vars: vars})
+	return err
+}
+
+// expand_template returns s with its placeholders expanded. s must have
+// been checked with check_template.
+func expand_template(s string, v *tmpl_vars) string {
+	out, _ := expand(s, v)
+	return out
+}
+
+func expand(s string, v *tmpl_vars) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(s[start:], "}}")
+		if end < 0 {
+			return "", fmt.Errorf("Unterminated placeholder in %q", s)
+		}
+		value, err := placeholder(strings.Fields(s[start+2:start+end]), v)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[start+end+2:]
+	}
+	b.WriteString(s)
+	return b.String(), nil
+}
+
+// placeholder returns the value of a placeholder, split in words.
+func placeholder(words []string, v *tmpl_vars) (string, error) {
+	if len(words) == 0 {
+		return "", errorString("Empty placeholder {{}}")
+	}
+	if column, ok := strings.CutPrefix(words[0], "."); ok && len(words) == 1 {
+		if value, ok := v.vars[column]; ok {
+			return value, nil
+		}
+		if v.data == nil {
+			return "", fmt.Errorf("Placeholder {{%s}} requires -data", words[0])
+		}
+		i, ok := v.data.columns[column]
+		if !ok {
+			return "", fmt.Errorf("Unknown -data column in placeholder {{%s}}", words[0])
+		}
+		if i < len(v.row) {
+			return v.row[i], nil
+		}
+		return "", nil
+	}
+	switch name := words[0]; {
+	case name == "uuid" && len(words) == 1:
+		return new_uuid(), nil
+	case name == "randint" && len(words) == 3:
+		lo, err1 := strconv.ParseInt(words[1], 10, 64)
+		hi, err2 := strconv.ParseInt(words[2], 10, 64)
+		if err1 != nil || err2 != nil || lo > hi {
+			return "", fmt.Errorf("Invalid placeholder {{%s}}, must be {{randint MIN MAX}}", strings.Join(words, " "))
+		}
+		return strconv.FormatInt(lo+mrand.Int63n(hi-lo+1), 10), nil
+	case name == "timestamp" && len(words) == 1:
+		return strconv.FormatInt(time.Now().Unix(), 10), nil
+	case name == "timestamp_ms" && len(words) == 1:
+		return strconv.FormatInt(time.Now().UnixMilli(), 10), nil
+	case name == "seq" && len(words) == 1:
+		return strconv.FormatInt(v.seq, 10), nil
+	}
+	return "", fmt.Errorf("Unknown placeholder {{%s}}", strings.Join(words, " "))
+}
+
+// new_uuid returns a random UUID (version 4).
+func new_uuid() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// expand_request sets the URL and header values of req, and returns its
+// body, with the placeholders of the job, or of the scenario endpoint e if
+// not nil, expanded for a new request number, the given data row and the
+// variables of the virtual user.
+func (j *job) expand_request(req *http.Request, e *endpoint, row []string, vars map[string]string) (string, error) {
+	v := &tmpl_vars{seq: j.seq.Add(1), data: j.data, row: row, vars: vars}
+	raw, body, hdr := j.url, j.body, j.hdr
+	if e != nil {
+		raw, body = e.raw, e.body
+		hdr = append(hdr[:len(hdr):len(hdr)], e.hdr...)
+	}
+	u, err := neturl.Parse(expand_template(raw, v))
+	if err != nil {
+		return "", err
+	}
+	req.URL, req.Host = u, u.Host
+	for _, hf := range hdr {
+		if strings.Contains(hf.value, "{{") {
+			req.Header.Del(hf.name)
+		}
+	}
+	for _, hf := range hdr {
+		if strings.Contains(hf.value, "{{") {
+			req.Header.Add(hf.name, strings.TrimSpace(expand_template(hf.value, v)))
+		}
+	}
+	return expand_template(body, v), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Number of recent intervals over which the sustained rate is averaged
+const throttle_window = 10
+
+// throttle adjusts the request rate at every sampling interval to hold the
+// 99th percentile latency under a target: the rate grows by 10% while the
+// target is met, and shrinks by 20% when it is not.
+type throttle struct {
+	pc     *pacer
+	target time.Duration
+	rate   float64
+	best   float64   // highest throughput of an interval meeting the target
+	recent []float64 // throughput of the last intervals
+}
+
+func new_throttle(pc *pacer, target time.Duration, initial float64) *throttle {
+	pc.set_rate(initial)
+	return &throttle{pc: pc, target: target, rate: initial}
+}
+
+// sample is the sample_hook adjusting the rate.
+func (t *throttle) sample(elapsed, interval time.Duration, s *stats) {
+	// Too short intervals, such as the last one, give unreliable figures
+	if s.requests == 0 || interval < 500*time.Millisecond {
+		return
+	}
+	tps := float64(s.requests) / interval.Seconds()
+	p99 := time.Duration(s.latency.value_at_percentile(99)) * time.Microsecond
+	if p99 <= t.target {
+		if tps > t.best {
+			t.best = tps
+		}
+		// Do not raise the rate beyond what the workers can actually send
+		t.rate = min(t.rate*1.1, tps*1.2)
+	} else {
+		t.rate *= 0.8
+	}
+	t.rate = max(t.rate, 1)
+	t.pc.set_rate(t.rate)
+
+	t.recent = append(t.recent, tps)
+	if len(t.recent) > throttle_window {
+		t.recent = t.recent[1:]
+	}
+}
+
+// throttle_report holds the outcome of a latency targeted run.
+type throttle_report struct {
+	Target    float64 `json:"target_p99_ms"`
+	Sustained float64 `json:"sustained_tps"` // mean of the last intervals
+	Best      float64 `json:"best_tps"`
+	FinalRate float64 `json:"final_rate"`
+}
+
+func (t *throttle) report() *throttle_report {
+	r := &throttle_report{
+		Target:    float64(t.target) / float64(time.Millisecond),
+		Best:      t.best,
+		FinalRate: t.rate,
+	}
+	for _, tps := range t.recent {
+		r.Sustained += tps / float64(len(t.recent))
+	}
+	return r
+}
+
+// print_throttle writes the outcome of a latency targeted run.
+func print_throttle(w io.Writer, r *throttle_report) {
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(w, "Rate for p99 <= %g ms: sustained %.2f tps (last %d intervals), best %.2f tps, final target %.2f tps\n",
+		r.Target, r.Sustained, throttle_window, r.Best, r.FinalRate)
+}
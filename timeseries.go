@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// timeseries writes one CSV row per sampling interval, with the load
+// settings at its end, which may change during the run.
+type timeseries struct {
+	w    *csv.Writer
+	pc   *pacer // nil unless the run is paced
+	g    *gate  // nil unless the number of active workers varies
+	conc int
+}
+
+func new_timeseries(w io.Writer, pc *pacer, g *gate, conc int) *timeseries {
+	ts := &timeseries{w: csv.NewWriter(w), pc: pc, g: g, conc: conc}
+	ts.w.Write([]string{"elapsed_seconds", "requests", "errors", "throughput_tps",
+		"latency_p50_ms", "latency_p90_ms", "latency_p99_ms", "latency_max_ms",
+		"target_rate", "active_connections"})
+	return ts
+}
+
+func format_float(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
+
+// sample is the sample_hook writing the row of an interval.
+func (ts *timeseries) sample(elapsed, interval time.Duration, s *stats) {
+	var tps float64
+	if interval > 0 {
+		tps = float64(s.requests) / interval.Seconds()
+	}
+	var target string
+	if ts.pc != nil {
+		target = format_float(ts.pc.rate())
+	}
+	active := ts.conc
+	if ts.g != nil {
+		active = ts.g.get()
+	}
+	ts.w.Write([]string{
+		format_float(elapsed.Seconds()),
+		strconv.FormatInt(s.requests, 10),
+		strconv.FormatInt(s.errors, 10),
+		format_float(tps),
+		format_float(usec_to_ms(float64(s.latency.value_at_percentile(50)))),
+		format_float(usec_to_ms(float64(s.latency.value_at_percentile(90)))),
+		format_float(usec_to_ms(float64(s.latency.value_at_percentile(99)))),
+		format_float(usec_to_ms(float64(s.latency.maximum()))),
+		target,
+		strconv.Itoa(active),
+	})
+	ts.w.Flush()
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// load_ca returns a pool of the certificates of a PEM bundle, trusted
+// instead of the system ones.
+func load_ca(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificate found", file)
+	}
+	return pool, nil
+}
+
+// client_certs loads the client certificate of -cert and -key or, when
+// -cert is a directory, those of its NAME.pem or NAME.crt files paired with
+// a NAME.key file. Each new connection presents the next one in turn.
+func client_certs(cert, key string) (func(*tls.CertificateRequestInfo) (*tls.Certificate, error), int, error) {
+	var certs []tls.Certificate
+	if fi, err := os.Stat(cert); err == nil && fi.IsDir() {
+		if key != "" {
+			return nil, 0, errorString("-key cannot be used with a -cert directory")
+		}
+		files, err := os.ReadDir(cert)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, f := range files {
+			ext := filepath.Ext(f.Name())
+			if ext != ".pem" && ext != ".crt" {
+				continue
+			}
+			base := filepath.Join(cert, strings.TrimSuffix(f.Name(), ext))
+			if _, err := os.Stat(base + ".key"); err != nil {
+				continue
+			}
+			c, err := tls.LoadX509KeyPair(base+ext, base+".key")
+			if err != nil {
+				return nil, 0, err
+			}
+			certs = append(certs, c)
+		}
+		if len(certs) == 0 {
+			return nil, 0, fmt.Errorf("%s: no certificate and key pair found", cert)
+		}
+	} else {
+		if key == "" {
+			key = cert // key in the same PEM file
+		}
+		c, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, 0, err
+		}
+		certs = append(certs, c)
+	}
+	var next atomic.Uint64
+	return func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &certs[(next.Add(1)-1)%uint64(len(certs))], nil
+	}, len(certs), nil
+}
+
+var tls_versions = map[string]uint16{"1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// tls_version is a flag.Value holding a TLS version, written 1.0 to 1.3.
+type tls_version uint16
+
+func (v *tls_version) String() string {
+	for name, version := range tls_versions {
+		if uint16(*v) == version {
+			return name
+		}
+	}
+	return ""
+}
+
+func (v *tls_version) Set(value string) error {
+	version, ok := tls_versions[strings.TrimPrefix(value, "TLS")]
+	if !ok {
+		return errorString("TLS version must be 1.0, 1.1, 1.2 or 1.3")
+	}
+	*v = tls_version(version)
+	return nil
+}
+
+// parse_ciphers returns the IDs of a comma-separated list of cipher suite
+// names, as listed by crypto/tls (insecure ones included).
+func parse_ciphers(list string) ([]uint16, error) {
+	by_name := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		by_name[cs.Name] = cs.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		id, ok := by_name[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// pins is a flag.Value holding the accepted SHA-256 hashes of certificate
+// public keys (SPKI), written sha256/BASE64 as in HPKP (or sha256//BASE64
+// as in curl).
+type pins [][sha256.Size]byte
+
+func (p *pins) String() string {
+	var list []string
+	for _, h := range *p {
+		list = append(list, "sha256/"+base64.StdEncoding.EncodeToString(h[:]))
+	}
+	return strings.Join(list, ",")
+}
+
+func (p *pins) Set(value string) error {
+	for _, pin := range strings.Split(value, ",") {
+		b64, ok := strings.CutPrefix(strings.TrimSpace(pin), "sha256/")
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(b64, "/"))
+		if !ok || err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("Invalid pin %q, must be sha256/ followed by a base64 SHA-256 hash", pin)
+		}
+		*p = append(*p, [sha256.Size]byte(b))
+	}
+	return nil
+}
+
+// pin_error is the failure of a handshake with a server whose certificates
+// match none of the pins.
+type pin_error struct{}
+
+func (pin_error) Error() string {
+	return "No certificate of the server matches the -pin hashes"
+}
+
+// verify is the tls.Config VerifyConnection hook checking that the public
+// key of a certificate presented by the server matches a pin.
+func (p pins) verify(cs tls.ConnectionState) error {
+	for _, cert := range cs.PeerCertificates {
+		if slices.Contains(p, sha256.Sum256(cert.RawSubjectPublicKeyInfo)) {
+			return nil
+		}
+	}
+	return pin_error{}
+}
+
+// downgrade returns why a handshake is weaker than the best one the client
+// offered, best being the highest TLS version offered, or "" if it is not:
+// a lower version, or a weak cipher suite (insecure, CBC or without forward
+// secrecy).
+func downgrade(cs tls.ConnectionState, best uint16) string {
+	if cs.Version < best {
+		return tls.VersionName(cs.Version)
+	}
+	name := tls.CipherSuiteName(cs.CipherSuite)
+	if cs.Version < tls.VersionTLS13 && (!strings.HasPrefix(name, "TLS_ECDHE_") || strings.Contains(name, "_CBC_")) {
+		return name
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.ID == cs.CipherSuite {
+			return name
+		}
+	}
+	return ""
+}
+
+// downgrade_error is the failure of a handshake rejected by
+// -tls-downgrade reject.
+type downgrade_error string
+
+func (e downgrade_error) Error() string {
+	return "TLS downgrade to " + string(e)
+}
+
+// cert_info describes a certificate presented by the server, with the
+// parameters of the first handshake that received it.
+type cert_info struct {
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the certificate, hex
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotAfter    time.Time `json:"not_after"`
+	Version     string    `json:"version"`
+	CipherSuite string    `json:"cipher_suite"`
+	Handshakes  int64     `json:"handshakes"`
+}
+
+// tls_tracker counts the TLS handshakes per negotiated version, cipher
+// suite and ALPN protocol, and those resuming a session. It also keeps the
+// distinct certificates presented by the servers and, with -tls-downgrade
+// warn, counts the downgraded handshakes.
+type tls_tracker struct {
+	mu         sync.Mutex
+	versions   map[string]int64
+	suites     map[string]int64
+	protocols  map[string]int64
+	resumed    int64
+	certs      map[[sha256.Size]byte]*cert_info
+	best       uint16 // highest version offered, 0 not to count downgrades
+	downgrades map[string]int64
+}
+
+func new_tls_tracker() *tls_tracker {
+	return &tls_tracker{versions: make(map[string]int64), suites: make(map[string]int64), protocols: make(map[string]int64),
+		certs: make(map[[sha256.Size]byte]*cert_info), downgrades: make(map[string]int64)}
+}
+
+// record counts one completed handshake.
+func (t *tls_tracker) record(cs tls.ConnectionState) {
+	t.mu.Lock()
+	t.versions[tls.VersionName(cs.Version)]++
+	t.suites[tls.CipherSuiteName(cs.CipherSuite)]++
+	if cs.NegotiatedProtocol != "" {
+		t.protocols[cs.NegotiatedProtocol]++
+	} else {
+		t.protocols["none"]++
+	}
+	if cs.DidResume {
+		t.resumed++
+	}
+	if t.best != 0 {
+		if reason := downgrade(cs, t.best); reason != "" {
+			t.downgrades[reason]++
+		}
+	}
+	if len(cs.PeerCertificates) > 0 {
+		leaf := cs.PeerCertificates[0]
+		sum := sha256.Sum256(leaf.Raw)
+		c := t.certs[sum]
+		if c == nil {
+			c = &cert_info{Fingerprint: hex.EncodeToString(sum[:]), Subject: leaf.Subject.String(), Issuer: leaf.Issuer.String(),
+				NotAfter: leaf.NotAfter, Version: tls.VersionName(cs.Version), CipherSuite: tls.CipherSuiteName(cs.CipherSuite)}
+			t.certs[sum] = c
+		}
+		c.Handshakes++
+	}
+	t.mu.Unlock()
+}
+
+// trace returns the client trace hook feeding the tracker.
+func (t *tls_tracker) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			if err == nil {
+				t.record(cs)
+			}
+		},
+	}
+}
+
+// tls_report holds the handshakes per negotiated TLS parameter.
+type tls_report struct {
+	Handshakes   int64            `json:"handshakes"`
+	Resumed      int64            `json:"resumed"`
+	Versions     map[string]int64 `json:"versions"`
+	CipherSuites map[string]int64 `json:"cipher_suites"`
+	ALPN         map[string]int64 `json:"alpn"` // negotiated protocols, none if not any
+	Certificates []*cert_info     `json:"certificates,omitempty"`
+	Downgrades   map[string]int64 `json:"downgrades,omitempty"` // per version or cipher suite, with -tls-downgrade warn
+}
+
+func (t *tls_tracker) report() *tls_report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := &tls_report{Versions: t.versions, CipherSuites: t.suites, ALPN: t.protocols, Resumed: t.resumed}
+	if len(t.downgrades) > 0 {
+		r.Downgrades = t.downgrades
+	}
+	for _, n := range t.versions {
+		r.Handshakes += n
+	}
+	r.Certificates = slices.SortedFunc(maps.Values(t.certs), func(a, b *cert_info) int {
+		return cmp.Compare(b.Handshakes, a.Handshakes)
+	})
+	return r
+}
+
+// print_counts writes the counts of m, sorted by key, after label.
+func print_counts(w io.Writer, label string, m map[string]int64) {
+	fmt.Fprintf(w, "  %s:", label)
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		fmt.Fprintf(w, " %s %d", k, m[k])
+	}
+	fmt.Fprintln(w)
+}
+
+// print_tls writes the TLS block of the summary.
+func print_tls(w io.Writer, r *tls_report) {
+	if r == nil || r.Handshakes == 0 {
+		return
+	}
+	fmt.Fprintf(w, "TLS: %d handshakes, %d full, %d resumed (%.2f%%)\n", r.Handshakes, r.Handshakes-r.Resumed, r.Resumed,
+		100*float64(r.Resumed)/float64(r.Handshakes))
+	print_counts(w, "versions", r.Versions)
+	print_counts(w, "cipher suites", r.CipherSuites)
+	print_counts(w, "ALPN protocols", r.ALPN)
+	if len(r.Downgrades) > 0 {
+		var n int64
+		for _, c := range r.Downgrades {
+			n += c
+		}
+		fmt.Fprintf(w, "  WARNING: %d downgraded handshakes (%.2f%%)\n", n, 100*float64(n)/float64(r.Handshakes))
+		print_counts(w, "downgraded to", r.Downgrades)
+	}
+	if len(r.Certificates) > 1 {
+		fmt.Fprintf(w, "  %d distinct server certificates:\n", len(r.Certificates))
+	}
+	for _, c := range r.Certificates {
+		fmt.Fprintf(w, "  certificate %s: %s, issued by %s, expires %s (in %d days), %s %s, %d handshakes\n",
+			c.Fingerprint[:16], c.Subject, c.Issuer, c.NotAfter.Format(time.DateOnly), int(time.Until(c.NotAfter).Hours()/24),
+			c.Version, c.CipherSuite, c.Handshakes)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"strings"
+)
+
+// read_urls returns the http or https URLs listed in a file, one per line,
+// skipping empty lines and # comments.
+func read_urls(file string) ([]*neturl.URL, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var urls []*neturl.URL
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		u, err := neturl.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s:%d: %q is not an http or https URL", file, line, s)
+		}
+		urls = append(urls, u)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s: no URL found", file)
+	}
+	return urls, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// window tracks throughput over a sliding window of sampling intervals, to
+// report the peak and minimum sustained throughput of the run.
+type window struct {
+	size     time.Duration
+	requests []int64
+	lengths  []time.Duration
+	peak     float64
+	min      float64
+	full     bool // at least one whole window was observed
+}
+
+func new_window(size time.Duration) *window {
+	return &window{size: size, min: math.MaxFloat64}
+}
+
+// sample is the sample_hook sliding the window by one interval.
+func (w *window) sample(elapsed, interval time.Duration, s *stats) {
+	w.requests = append(w.requests, s.requests)
+	w.lengths = append(w.lengths, interval)
+
+	var total int64
+	var length time.Duration
+	for i := range w.requests {
+		total += w.requests[i]
+		length += w.lengths[i]
+	}
+	// Allow for ticker jitter: half an interval short is a whole window
+	if length+interval/2 < w.size {
+		return
+	}
+	tps := float64(total) / length.Seconds()
+	if tps > w.peak {
+		w.peak = tps
+	}
+	if tps < w.min {
+		w.min = tps
+	}
+	w.full = true
+	w.requests = w.requests[1:]
+	w.lengths = w.lengths[1:]
+}